@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricCache holds the metrics of the last scrape
+type metricCache struct {
+	mu sync.Mutex
+
+	metrics []prometheus.Metric
+
+	// end of the scrape of the cached metrics
+	scraped time.Time
+
+	// a scrape for the cache is in flight
+	scraping bool
+}
+
+// cachedScrape returns the cached metrics if they are younger than the cache TTL or if another scrape
+// is in flight, otherwise the metrics are scraped and cached. It returns the time of their scrape
+func (c *DockerCollector) cachedScrape() ([]prometheus.Metric, time.Time) {
+	c.cache.mu.Lock()
+
+	if c.cache.metrics != nil && (c.cache.scraping || time.Since(c.cache.scraped) < c.cacheTTL) {
+		defer c.cache.mu.Unlock()
+
+		return c.cache.metrics, c.cache.scraped
+	}
+
+	c.cache.scraping = true
+	c.cache.mu.Unlock()
+
+	ch := make(chan prometheus.Metric)
+
+	go func() {
+		c.scrape(ch)
+		close(ch)
+	}()
+
+	var metrics []prometheus.Metric
+	for metric := range ch {
+		metrics = append(metrics, metric)
+	}
+
+	scraped := time.Now()
+
+	c.cache.mu.Lock()
+	c.cache.metrics, c.cache.scraped, c.cache.scraping = metrics, scraped, false
+	c.cache.mu.Unlock()
+
+	return metrics, scraped
+}
+
+// resetCache drops the cached metrics
+func (c *DockerCollector) resetCache() {
+	c.cache.mu.Lock()
+	c.cache.metrics = nil
+	c.cache.mu.Unlock()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCachedScrape_ServesStaleMetricsWhileScraping(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	t.Setenv("DEX_CACHE_TTL", "50ms")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newTestCollector(t))
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+
+	// the cached metrics expire and the next scrape is slow
+	time.Sleep(100 * time.Millisecond)
+	d.update(func(d *fakeDaemon) { d.statsDelay = time.Second })
+
+	slowScrape := make(chan error)
+
+	go func() {
+		_, err := reg.Gather()
+		slowScrape <- err
+	}()
+
+	for d.requestCount("/containers/aaaa/stats") < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cached metrics took %v, want them without waiting for the scrape in flight", elapsed)
+	}
+
+	if age := metricValue(t, families, "dex_cache_age_seconds", nil); age < 0.1 {
+		t.Errorf("dex_cache_age_seconds = %v, want the age of the first scrape", age)
+	}
+
+	if findMetric(families, "dex_cpu_utilization_percent", map[string]string{"container_name": "web"}) == nil {
+		t.Error("container metrics of the cache are missing")
+	}
+
+	if err := <-slowScrape; err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+}
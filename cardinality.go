@@ -0,0 +1,94 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// fqName of a Desc, which has no accessor for it
+var descFqName = regexp.MustCompile(`fqName: "([^"]*)"`)
+
+// CardinalityLimiter wraps a collector and drops the metrics exceeding the maximal number of label
+// value combinations per metric name. The combinations are counted per scrape
+type CardinalityLimiter struct {
+	collector prometheus.Collector
+
+	// maximal number of label value combinations per metric name and scrape
+	maxCardinality int
+
+	drops *prometheus.CounterVec
+}
+
+func NewCardinalityLimiter(collector prometheus.Collector, maxCardinality int, metricPrefix string) *CardinalityLimiter {
+	return &CardinalityLimiter{
+		collector:      collector,
+		maxCardinality: maxCardinality,
+		drops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "cardinality_limit_drops_total",
+			Help:      "Number of metrics dropped because the maximal number of label value combinations was reached",
+		}, []string{"metric_name"}),
+	}
+}
+
+// Describe delegates to the wrapped collector and describes the dropped metrics counter
+func (l *CardinalityLimiter) Describe(ch chan<- *prometheus.Desc) {
+	l.collector.Describe(ch)
+	l.drops.Describe(ch)
+}
+
+func (l *CardinalityLimiter) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+
+	go func() {
+		l.collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	// descriptor -> label value combinations of this scrape, each metric name has one descriptor
+	seen := make(map[*prometheus.Desc]map[string]bool)
+
+	for metric := range metrics {
+		desc := metric.Desc()
+
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			log.Error("can't write metric: ", err)
+			continue
+		}
+
+		values := make([]string, 0, len(m.GetLabel()))
+		for _, label := range m.GetLabel() {
+			values = append(values, label.GetValue())
+		}
+
+		key := strings.Join(values, "\xff")
+
+		if seen[desc] == nil {
+			seen[desc] = make(map[string]bool)
+		}
+
+		if !seen[desc][key] && len(seen[desc]) >= l.maxCardinality {
+			l.drops.WithLabelValues(metricName(metric)).Inc()
+			continue
+		}
+
+		seen[desc][key] = true
+		ch <- metric
+	}
+
+	l.drops.Collect(ch)
+}
+
+// metricName returns the fully qualified name of the metric
+func metricName(metric prometheus.Metric) string {
+	if match := descFqName.FindStringSubmatch(metric.Desc().String()); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCardinalityLimiter_DropsMetricsOverTheLimit(t *testing.T) {
+	reads := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "dex_reads", Help: "Reads"}, []string{"container_name"})
+	for i := 0; i < 5; i++ {
+		reads.WithLabelValues("c" + strconv.Itoa(i)).Set(float64(i))
+	}
+
+	limiter := NewCardinalityLimiter(reads, 3, "dex")
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		families := gather(t, limiter)
+
+		var kept int
+		for _, family := range families {
+			if family.GetName() == "dex_reads" {
+				kept = len(family.GetMetric())
+			}
+		}
+
+		if kept != 3 {
+			t.Errorf("scrape %d: %d dex_reads metrics, want 3", scrape, kept)
+		}
+
+		// the combinations are counted per scrape, so each scrape drops the same metrics again
+		want := float64(2 * scrape)
+		if got := metricValue(t, families, "dex_cardinality_limit_drops_total", map[string]string{"metric_name": "dex_reads"}); got != want {
+			t.Errorf("scrape %d: dex_cardinality_limit_drops_total = %v, want %v", scrape, got, want)
+		}
+	}
+}
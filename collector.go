@@ -3,232 +3,2871 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/blkiodev"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/client"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var labelCname = []string{"container_name"}
 
+// default prefix of the metric names
+const defaultMetricPrefix = "dex"
+
+// metricDesc keeps the arguments of a descriptor for creating it with another prefix
+type metricDesc struct {
+	desc   *prometheus.Desc
+	name   string
+	help   string
+	labels []string
+}
+
+// descriptors created by newDesc
+var metricDescs []metricDesc
+
+// newDesc creates the descriptor template of a metric named with the default prefix. A collector
+// emits its own descriptor created from the template by newDescs
+func newDesc(name string, help string, labels []string) *prometheus.Desc {
+	desc := prometheus.NewDesc(defaultMetricPrefix+"_"+name, help, labels, nil)
+	metricDescs = append(metricDescs, metricDesc{desc: desc, name: name, help: help, labels: labels})
+
+	return desc
+}
+
+// newDescs creates the descriptors of all templates created by newDesc with the prefix. Container
+// metrics get the container labels, which the metric doesn't have already. The templates are not
+// changed, so collectors with different prefixes can coexist
+func newDescs(prefix string, containerLabels []string) map[*prometheus.Desc]*prometheus.Desc {
+	descs := make(map[*prometheus.Desc]*prometheus.Desc, len(metricDescs))
+
+	for _, d := range metricDescs {
+		labels := d.labels
+
+		if slices.Contains(labels, "container_name") {
+			labels = slices.Clone(labels)
+
+			for _, label := range containerLabels {
+				if !slices.Contains(labels, label) {
+					labels = append(labels, label)
+				}
+			}
+		}
+
+		descs[d.desc] = prometheus.NewDesc(prefix+"_"+d.name, d.help, labels, nil)
+	}
+
+	return descs
+}
+
+// descriptors of the metrics, created once instead of on every scrape
+var (
+	cacheAgeSecondsDesc = newDesc(
+		"cache_age_seconds",
+		"Age of the served metrics in seconds, 0 if they were scraped for this request",
+		nil,
+	)
+
+	containerSamplingRateDesc = newDesc(
+		"container_sampling_rate",
+		"Fraction of the running containers whose stats are collected per scrape",
+		nil,
+	)
+
+	hostContainersTotalDesc = newDesc(
+		"host_containers_total",
+		"Number of containers of the docker host",
+		nil,
+	)
+
+	hostContainersRunningDesc = newDesc(
+		"host_containers_running",
+		"Number of running containers of the docker host",
+		nil,
+	)
+
+	hostContainersStoppedDesc = newDesc(
+		"host_containers_stopped",
+		"Number of created, exited or dead containers of the docker host",
+		nil,
+	)
+
+	hostContainersPausedDesc = newDesc(
+		"host_containers_paused",
+		"Number of paused containers of the docker host",
+		nil,
+	)
+
+	dockerContainersHostNetworkTotalDesc = newDesc(
+		"docker_containers_host_network_total",
+		"Number of running containers using the host network",
+		nil,
+	)
+
+	containerRunningDesc = newDesc(
+		"container_running",
+		"1 if docker container is running, 0 otherwise",
+		labelCname,
+	)
+
+	containerRestartingDesc = newDesc(
+		"container_restarting",
+		"1 if docker container is restarting, 0 otherwise",
+		labelCname,
+	)
+
+	containerExitedDesc = newDesc(
+		"container_exited",
+		"1 if docker container exited, 0 otherwise",
+		labelCname,
+	)
+
+	containerStateDesc = newDesc(
+		"container_state",
+		"State of the docker container, always 1",
+		[]string{"container_name", "state"},
+	)
+
+	containerRestartsTotalDesc = newDesc(
+		"container_restarts_total",
+		"Number of times the container has restarted",
+		labelCname,
+	)
+
+	containerExitCodeDesc = newDesc(
+		"container_exit_code",
+		"Exit code of the last run of the container, 0 if it is running",
+		labelCname,
+	)
+
+	containerStaleDesc = newDesc(
+		"container_stale",
+		"1 if no stats could be collected for the running container within the stale threshold, 0 otherwise",
+		labelCname,
+	)
+
+	cpuUtilizationPercentDesc = newDesc(
+		"cpu_utilization_percent",
+		"CPU utilization in percent",
+		labelCname,
+	)
+
+	cpuCountDesc = newDesc(
+		"cpu_count",
+		"Number of CPUs available to the container",
+		labelCname,
+	)
+
+	cpuUtilizationSecondsTotalDesc = newDesc(
+		"cpu_utilization_seconds_total",
+		"Cumulative CPU utilization in seconds",
+		labelCname,
+	)
+
+	cpuThrottlePeriodsTotalDesc = newDesc(
+		"cpu_throttle_periods_total",
+		"Number of CPU enforcement periods of the container",
+		labelCname,
+	)
+
+	cpuThrottledPeriodsTotalDesc = newDesc(
+		"cpu_throttled_periods_total",
+		"Number of CPU enforcement periods in which the container was throttled",
+		labelCname,
+	)
+
+	cpuThrottledSecondsTotalDesc = newDesc(
+		"cpu_throttled_seconds_total",
+		"Total time the container was throttled in seconds",
+		labelCname,
+	)
+
+	containerCPUUsageNanosecondsDeltaDesc = newDesc(
+		"container_cpu_usage_nanoseconds_delta",
+		"CPU time used by the container between the last two stats snapshots in nanoseconds",
+		labelCname,
+	)
+
+	containerCPUSystemNanosecondsDeltaDesc = newDesc(
+		"container_cpu_system_nanoseconds_delta",
+		"CPU time of the host between the last two stats snapshots in nanoseconds",
+		labelCname,
+	)
+
+	containerCPUQuotaRatioDesc = newDesc(
+		"container_cpu_quota_ratio",
+		"Number of CPUs the container may use (CPU quota / CPU period), 0 if unlimited",
+		labelCname,
+	)
+
+	containerCPUPeriodSecondsDesc = newDesc(
+		"container_cpu_period_seconds",
+		"Configured CFS period of the container in seconds, the kernel default of 0.1 if not configured",
+		labelCname,
+	)
+
+	cpuQuotaNanocpusDesc = newDesc(
+		"cpu_quota_nanocpus",
+		"Configured CPU limit of the container in billionths of a CPU, +Inf if unlimited",
+		labelCname,
+	)
+
+	cpuPeriodMicrosecondsDesc = newDesc(
+		"cpu_period_microseconds",
+		"Configured CFS period of the container in microseconds, the kernel default of 100000 if not configured",
+		labelCname,
+	)
+
+	containerCPUCgroupV2Desc = newDesc(
+		"container_cpu_cgroup_v2",
+		"1 if the CPU stats of the container are from cgroups v2, 0 if from cgroups v1, -1 if unknown",
+		labelCname,
+	)
+
+	containerCPUPercentLimitDesc = newDesc(
+		"container_cpu_percent_limit",
+		"CPU limit of the container in percent of the total host CPU capacity",
+		labelCname,
+	)
+
+	containerCPUWeightDesc = newDesc(
+		"container_cpu_weight",
+		"Relative CPU priority of the container",
+		labelCname,
+	)
+
+	containerCPULoadAverage10sDesc = newDesc(
+		"container_cpu_load_average_10s",
+		"Estimated CPU demand exceeding the CPU quota in CPUs, smoothed over 10 seconds",
+		labelCname,
+	)
+
+	containerNetworkStatsMissingDesc = newDesc(
+		"container_network_stats_missing",
+		"1 if no network stats are available for the container",
+		[]string{"container_name", "reason"},
+	)
+
+	networkRxBytesTotalDesc = newDesc(
+		"network_rx_bytes_total",
+		"Network received bytes total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkTxBytesTotalDesc = newDesc(
+		"network_tx_bytes_total",
+		"Network sent bytes total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkRxPacketsTotalDesc = newDesc(
+		"network_rx_packets_total",
+		"Network received packets total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkTxPacketsTotalDesc = newDesc(
+		"network_tx_packets_total",
+		"Network sent packets total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkRxErrorsTotalDesc = newDesc(
+		"network_rx_errors_total",
+		"Network receive errors total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkTxErrorsTotalDesc = newDesc(
+		"network_tx_errors_total",
+		"Network send errors total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkRxDroppedTotalDesc = newDesc(
+		"network_rx_dropped_total",
+		"Network received packets dropped total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	networkTxDroppedTotalDesc = newDesc(
+		"network_tx_dropped_total",
+		"Network sent packets dropped total per interface",
+		[]string{"container_name", "interface"},
+	)
+
+	containerNetworkTotalRxBytesTotalDesc = newDesc(
+		"container_network_total_rx_bytes_total",
+		"Network received bytes total across all interfaces",
+		labelCname,
+	)
+
+	containerNetworkTotalTxBytesTotalDesc = newDesc(
+		"container_network_total_tx_bytes_total",
+		"Network sent bytes total across all interfaces",
+		labelCname,
+	)
+
+	containerNetworkInterfaceCountDesc = newDesc(
+		"container_network_interface_count",
+		"Number of network interfaces of the container",
+		labelCname,
+	)
+
+	containerNetworkTopologyChangeTotalDesc = newDesc(
+		"container_network_topology_change_total",
+		"Number of changes of the number of network interfaces of the container between scrapes",
+		labelCname,
+	)
+
+	memoryUsageBytesDesc = newDesc(
+		"memory_usage_bytes",
+		"Total memory usage bytes",
+		labelCname,
+	)
+
+	memoryTotalBytesDesc = newDesc(
+		"memory_total_bytes",
+		"Total memory bytes",
+		labelCname,
+	)
+
+	memoryUtilizationPercentDesc = newDesc(
+		"memory_utilization_percent",
+		"Memory utilization percent",
+		labelCname,
+	)
+
+	containerMemoryLimitSoftBytesDesc = newDesc(
+		"container_memory_limit_soft_bytes",
+		"Soft memory limit bytes, unlike the hard limit it may be exceeded until the host is under memory pressure",
+		labelCname,
+	)
+
+	containerMemoryPercentLimitDesc = newDesc(
+		"container_memory_percent_limit",
+		"Memory limit of the container in percent of the total host memory",
+		labelCname,
+	)
+
+	containerOomScoreAdjDesc = newDesc(
+		"container_oom_score_adj",
+		"OOM killer score adjustment of the container (-1000 to 1000)",
+		labelCname,
+	)
+
+	containerOomKillDisableDesc = newDesc(
+		"container_oom_kill_disable",
+		"1 if the OOM killer is disabled for the container, 0 otherwise",
+		labelCname,
+	)
+
+	containerSwapLimitBytesDesc = newDesc(
+		"container_swap_limit_bytes",
+		"Configured swap limit bytes, +Inf if unlimited and 0 if disabled. The usage is dex_memory_swap_usage_bytes",
+		labelCname,
+	)
+
+	containerCgroupVersionDesc = newDesc(
+		"container_cgroup_version",
+		"cgroup version of the container detected from the memory stats",
+		[]string{"container_name", "version"},
+	)
+
+	containerMemoryPgfaultTotalDesc = newDesc(
+		"container_memory_pgfault_total",
+		"Total number of page faults",
+		labelCname,
+	)
+
+	containerMemoryPgmajfaultTotalDesc = newDesc(
+		"container_memory_pgmajfault_total",
+		"Total number of major page faults",
+		labelCname,
+	)
+
+	containerMemorySwapFailcntTotalDesc = newDesc(
+		"container_memory_swap_failcnt_total",
+		"Number of times the memory and swap limit of the container was hit",
+		labelCname,
+	)
+
+	memoryOomKillsTotalDesc = newDesc(
+		"memory_oom_kills_total",
+		"Number of OOM kills in the container (cgroups v2), the number of times the memory limit was hit if not reported",
+		labelCname,
+	)
+
+	memorySwapUsageBytesDesc = newDesc(
+		"memory_swap_usage_bytes",
+		"Swap usage bytes",
+		labelCname,
+	)
+
+	memorySwapLimitBytesDesc = newDesc(
+		"memory_swap_limit_bytes",
+		"Swap limit bytes, +Inf if unlimited",
+		labelCname,
+	)
+
+	containerMemoryTcpBufferBytesDesc = newDesc(
+		"container_memory_tcp_buffer_bytes",
+		"Kernel memory used by the TCP socket buffers of the container in bytes",
+		labelCname,
+	)
+
+	containerMemoryLimitNearTotalDesc = newDesc(
+		"container_memory_limit_near_total",
+		"Number of scrapes in which the memory usage of the container was near its limit",
+		labelCname,
+	)
+
+	blockIoReadBytesTotalDesc = newDesc(
+		"block_io_read_bytes_total",
+		"Block I/O read bytes per device (major:minor), device \"total\" for all devices",
+		[]string{"container_name", "device"},
+	)
+
+	blockIoWriteBytesTotalDesc = newDesc(
+		"block_io_write_bytes_total",
+		"Block I/O write bytes per device (major:minor), device \"total\" for all devices",
+		[]string{"container_name", "device"},
+	)
+
+	containerBlockIoDiscardBytesTotalDesc = newDesc(
+		"container_block_io_discard_bytes_total",
+		"Block I/O discarded bytes",
+		labelCname,
+	)
+
+	blockIoReadOpsTotalDesc = newDesc(
+		"block_io_read_ops_total",
+		"Block I/O read operations",
+		labelCname,
+	)
+
+	blockIoWriteOpsTotalDesc = newDesc(
+		"block_io_write_ops_total",
+		"Block I/O write operations",
+		labelCname,
+	)
+
+	containerBlockIoDiscardOpsTotalDesc = newDesc(
+		"container_block_io_discard_ops_total",
+		"Block I/O discard operations",
+		labelCname,
+	)
+
+	containerBlockIoWaitTimeSecondsTotalDesc = newDesc(
+		"container_block_io_wait_time_seconds_total",
+		"Block I/O wait time in seconds",
+		labelCname,
+	)
+
+	containerBlockIoReadAvgLatencyMsDesc = newDesc(
+		"container_block_io_read_avg_latency_ms",
+		"Average block I/O read latency per operation in milliseconds",
+		labelCname,
+	)
+
+	containerBlockIoWriteAvgLatencyMsDesc = newDesc(
+		"container_block_io_write_avg_latency_ms",
+		"Average block I/O write latency per operation in milliseconds",
+		labelCname,
+	)
+
+	containerBlockIoDeviceWaitTimeSecondsTotalDesc = newDesc(
+		"container_block_io_device_wait_time_seconds_total",
+		"Block I/O wait time in seconds per device",
+		[]string{"container_name", "device"},
+	)
+
+	pidsCurrentDesc = newDesc(
+		"pids_current",
+		"Current number of pids in the cgroup",
+		labelCname,
+	)
+
+	containerPidsMaxDesc = newDesc(
+		"container_pids_max",
+		"Configured maximum number of pids in the container, 0 if unlimited",
+		labelCname,
+	)
+
+	pidsLimitDesc = newDesc(
+		"pids_limit",
+		"Maximum number of pids in the cgroup, +Inf if unlimited",
+		labelCname,
+	)
+
+	containerProcessCountDesc = newDesc(
+		"container_process_count",
+		"Number of processes running in the container",
+		labelCname,
+	)
+
+	containerExecCountDesc = newDesc(
+		"container_exec_count",
+		"Number of processes running in the container besides the main process",
+		labelCname,
+	)
+
+	containerAttachInfoDesc = newDesc(
+		"container_attach_info",
+		"Whether the container stream is attached",
+		[]string{"container_name", "stream", "attached"},
+	)
+
+	containerDeviceReadBpsLimitDesc = newDesc(
+		"container_device_read_bps_limit",
+		"Configured read rate limit in bytes per second of the device",
+		[]string{"container_name", "device"},
+	)
+
+	containerDeviceWriteBpsLimitDesc = newDesc(
+		"container_device_write_bps_limit",
+		"Configured write rate limit in bytes per second of the device",
+		[]string{"container_name", "device"},
+	)
+
+	containerDeviceReadIopsLimitDesc = newDesc(
+		"container_device_read_iops_limit",
+		"Configured read rate limit in IO operations per second of the device",
+		[]string{"container_name", "device"},
+	)
+
+	containerDeviceWriteIopsLimitDesc = newDesc(
+		"container_device_write_iops_limit",
+		"Configured write rate limit in IO operations per second of the device",
+		[]string{"container_name", "device"},
+	)
+
+	containerStopSignalInfoDesc = newDesc(
+		"container_stop_signal_info",
+		"Signal sent to the container to stop it",
+		[]string{"container_name", "signal"},
+	)
+
+	containerStopTimeoutSecondsDesc = newDesc(
+		"container_stop_timeout_seconds",
+		"Seconds to wait after the stop signal before the container is killed",
+		labelCname,
+	)
+
+	containerThreadCountDesc = newDesc(
+		"container_thread_count",
+		"Number of threads of all processes in the container",
+		labelCname,
+	)
+
+	containerOpenFileDescriptorsDesc = newDesc(
+		"container_open_file_descriptors",
+		"Number of open file descriptors of all processes in the container",
+		labelCname,
+	)
+
+	containerTcpConnectionsEstablishedDesc = newDesc(
+		"container_tcp_connections_established",
+		"Number of established TCP connections of the container",
+		labelCname,
+	)
+
+	containerFsRwBytesDesc = newDesc(
+		"container_fs_rw_bytes",
+		"Size of the read-write layer of the container filesystem in bytes",
+		labelCname,
+	)
+
+	containerFsTotalBytesDesc = newDesc(
+		"container_fs_total_bytes",
+		"Total size of the container root filesystem including the image layers in bytes",
+		labelCname,
+	)
+
+	containerHealthCheckFailureTotalDesc = newDesc(
+		"container_health_check_failure_total",
+		"Number of failed health checks of the container observed in its health check log",
+		labelCname,
+	)
+
+	containerHealthCheckLastFailureInfoDesc = newDesc(
+		"container_health_check_last_failure_info",
+		"Output of the last failed health check of the container, truncated to 64 characters",
+		[]string{"container_name", "last_output"},
+	)
+
+	containerHealthStatusDesc = newDesc(
+		"container_health_status",
+		"Health check status of the container: 1 healthy, 0 unhealthy, -1 starting, -2 no health check",
+		labelCname,
+	)
+
+	containerCPUBurstPeriodsTotalDesc = newDesc(
+		"container_cpu_burst_periods_total",
+		"Number of periods in which the container used CPU burst",
+		labelCname,
+	)
+
+	containerStorageDriverInfoDesc = newDesc(
+		"container_storage_driver_info",
+		"Storage driver of the container filesystem",
+		[]string{"container_name", "driver"},
+	)
+
+	containerImageLastPullTimestampSecondsDesc = newDesc(
+		"container_image_last_pull_timestamp_seconds",
+		"Unix timestamp when the image of the container was last pulled or tagged on this host",
+		[]string{"container_name", "image_id"},
+	)
+
+	containerImageFreshnessDaysDesc = newDesc(
+		"container_image_freshness_days",
+		"Days since the image of the container was last pulled or tagged on this host",
+		labelCname,
+	)
+
+	containerImageCreationAgeDaysDesc = newDesc(
+		"container_image_creation_age_days",
+		"Days since the image of the container was built",
+		labelCname,
+	)
+
+	containerImageCreationDateInfoDesc = newDesc(
+		"container_image_creation_date_info",
+		"Build date of the image of the container",
+		[]string{"container_name", "created_date"},
+	)
+
+	containerCPUPerCoreUtilizationPercentDesc = newDesc(
+		"container_cpu_per_core_utilization_percent",
+		"Distribution of the CPU utilization of the container over the CPU cores in percent",
+		labelCname,
+	)
+
+	containerMemoryPressureEventsTotalDesc = newDesc(
+		"container_memory_pressure_events_total",
+		"Number of memory pressure events of the container by level",
+		[]string{"container_name", "level"},
+	)
+
+	containerCgroupParentInfoDesc = newDesc(
+		"container_cgroup_parent_info",
+		"Parent cgroup of the container",
+		[]string{"container_name", "cgroup_parent"},
+	)
+
+	containerUsernsRemappedDesc = newDesc(
+		"container_userns_remapped",
+		"1 if the user namespace of the container is remapped, 0 otherwise",
+		labelCname,
+	)
+
+	containerRuntimeClassInfoDesc = newDesc(
+		"container_runtime_class_info",
+		"Kubernetes RuntimeClass of the pod of the container",
+		[]string{"container_name", "runtime_class"},
+	)
+
+	containerImageTagInfoDesc = newDesc(
+		"container_image_tag_info",
+		"Image name and tag of the container",
+		[]string{"container_name", "image_name", "image_tag"},
+	)
+
+	containerLastDieExitCodeDesc = newDesc(
+		"container_last_die_exit_code",
+		"Exit code of the last die event of the container",
+		labelCname,
+	)
+
+	containerCrashTotalDesc = newDesc(
+		"container_crash_total",
+		"Number of die events of the container with an exit code indicating a crash (137: sigkill, 139: segfault)",
+		[]string{"container_name", "reason"},
+	)
+
+	containerStartTimestampSecondsDesc = newDesc(
+		"container_start_timestamp_seconds",
+		"Unix timestamp of the last start of the container, 0 if it was never started",
+		labelCname,
+	)
+)
+
+// scrapeInfo holds data shared by all containers of a single scrape
+type scrapeInfo struct {
+	system.Info
+
+	// number of running containers
+	runningContainers int
+
+	// sum of the CPU shares of all running containers
+	totalCPUShares int64
+
+	// image ID -> *imageInspect, each image is inspected once per scrape
+	images sync.Map
+}
+
+// imageInspect is the result of an image inspect shared by the containers of a scrape
+type imageInspect struct {
+	once  sync.Once
+	image types.ImageInspect
+	err   error
+}
+
 type DockerCollector struct {
 	cli *client.Client
+
+	// prefix of the metric names
+	metricPrefix string
+
+	// descriptor template -> descriptor with the metric prefix and the container labels
+	descs map[*prometheus.Desc]*prometheus.Desc
+
+	// emit block I/O metrics per device in addition to the container totals
+	blockIoPerDevice bool
+
+	// only collect containers having a label key with one of these prefixes, all containers if empty
+	labelPrefixFilter []string
+
+	// containers with a matching name are collected, even if they match excludeContainers
+	includeContainers *regexp.Regexp
+
+	// containers with a matching name are not collected
+	excludeContainers *regexp.Regexp
+
+	// docker labels added as Prometheus labels to all metrics of the container
+	extraLabels []extraLabel
+
+	// add the docker compose project and service as Prometheus labels to all metrics of the container
+	composeLabels bool
+
+	// running containers without successful stats for this duration are flagged as stale
+	staleThreshold time.Duration
+
+	// emit the number of processes besides the main process
+	execCountMetrics bool
+
+	// fraction of the running containers whose stats are collected per scrape
+	samplingRate float64
+
+	// maximal number of containers processed concurrently per scrape
+	scrapeConcurrency int
+
+	// the docker API calls of a scrape are canceled after this duration
+	scrapeTimeout time.Duration
+
+	// stages of the metric collection for running containers
+	collectors []MetricCollector
+
+	// container ID -> throttlingSample of the previous scrape
+	prevThrottling sync.Map
+
+	// container ID -> time.Time of the last successful stats collection
+	lastStatsTime sync.Map
+
+	// container ID -> interfaceSample of the previous scrape
+	prevInterfaces sync.Map
+
+	// memory usage from this fraction of the limit on counts as near the limit
+	memoryLimitWarnThreshold float64
+
+	// container ID -> uint64 number of scrapes with memory usage near the limit
+	memoryLimitNear sync.Map
+
+	// open file descriptors are counted at most once per interval
+	fdMetricsInterval time.Duration
+
+	// container ID -> healthSample of the failed health checks
+	healthFailures sync.Map
+
+	// container ID -> fdSample of the last count
+	fdSamples sync.Map
+
+	// filesystem sizes are calculated at most once per interval
+	fsMetricsInterval time.Duration
+
+	// container ID -> fsSample of the last size calculation
+	fsSamples sync.Map
+
+	// maximal number of containers processed per scrape, unlimited if 0
+	maxContainers int
+
+	// containers skipped because of maxContainers
+	skippedContainers prometheus.Counter
+
+	// container ID -> struct{} of all containers seen in the lifetime of the collector
+	seenContainers sync.Map
+
+	// containers seen for the first time and containers which disappeared
+	createdContainers prometheus.Counter
+	removedContainers prometheus.Counter
+
+	// failed docker API calls per container and error type
+	scrapeErrors *prometheus.CounterVec
+
+	// container_name -> struct{} of the containers with scrape errors
+	scrapeErrorNames sync.Map
+
+	// containers whose container_name label collided with another container
+	nameConflicts prometheus.Counter
+
+	// container ID -> struct{} of the containers already counted in nameConflicts
+	nameConflictIDs sync.Map
+
+	// run durations of exited containers
+	runDuration *prometheus.HistogramVec
+
+	// container ID -> FinishedAt of the last run observed in runDuration
+	observedRuns sync.Map
+
+	// container ID -> dieSample of the die events
+	dieEvents sync.Map
+
+	// count memory pressure events, only enabled with cgroups v2
+	memoryPressureEvents bool
+
+	// container ID -> uint64 number of OOM events
+	oomEvents sync.Map
+
+	// image pull and push events per image name
+	imagePulls    *prometheus.CounterVec
+	imagePushes   *prometheus.CounterVec
+	lastImagePull *prometheus.GaugeVec
+
+	// duration of the whole Collect call
+	scrapeDuration prometheus.Histogram
+
+	// containers processed by the scrapes
+	scrapeContainers prometheus.Counter
+
+	// metrics are served from the cache for this duration, the cache is disabled if 0
+	cacheTTL time.Duration
+
+	cache metricCache
+}
+
+// fsSample holds the last calculated filesystem sizes of a container
+type fsSample struct {
+	calculated time.Time
+	sizeRw     int64
+	sizeRootFs int64
+}
+
+// healthSample holds the number of failed health checks of a container and the end of the last counted one
+type healthSample struct {
+	failures uint64
+	counted  time.Time
+}
+
+// fdSample holds the last count of open file descriptors of a container, counted is the time of
+// the last attempt and ok whether it succeeded
+type fdSample struct {
+	counted time.Time
+	ok      bool
+	fds     int
+}
+
+// interfaceSample holds the number of network interfaces of a container and how often it changed
+type interfaceSample struct {
+	interfaces int
+	changes    uint64
+}
+
+// dieSample holds the exit code of the last die event of a container and the number of crashes by reason
+type dieSample struct {
+	exitCode int
+	crashes  map[string]uint64
+}
+
+// throttlingSample holds the CPU throttling counters of a scrape for computing the load estimate
+type throttlingSample struct {
+	read             time.Time
+	periods          uint64
+	throttledPeriods uint64
+	load             float64
+}
+
+// newDockerCollector creates the collector and starts watching the docker events until ctx is done
+func newDockerCollector(ctx context.Context, cfg DexConfig) *DockerCollector {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+
+	if cfg.DockerHost != "" {
+		if socket, isSocket := strings.CutPrefix(cfg.DockerHost, "unix://"); isSocket {
+			if _, err := os.Stat(socket); err != nil {
+				log.Fatalf("docker socket '%s' of DEX_DOCKER_HOST does not exist: %v", socket, err)
+			}
+		}
+
+		opts = append(opts, client.WithHost(cfg.DockerHost))
+	}
+
+	if cfg.DockerTLS() {
+		opts = append(opts, client.WithTLSClientConfig(cfg.DockerTLSCACert, cfg.DockerTLSCert, cfg.DockerTLSKey))
+	}
+
+	if cfg.DockerContext != "" {
+		configDir, err := dockerConfigDir()
+		if err != nil {
+			log.Fatalf("can't find docker config directory: %v", err)
+		}
+
+		contextOpts, err := dockerContextOpts(configDir, cfg.DockerContext)
+		if err != nil {
+			log.Fatalf("can't load docker context: %v", err)
+		}
+
+		opts = append(opts, contextOpts...)
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		log.Fatalf("can't create docker client: %v", err)
+	}
+
+	if cfg.DockerTLS() {
+		if err := verifyTLSConnection(cli); err != nil {
+			log.Fatalf("can't connect to docker daemon at %s with TLS: %v", cli.DaemonHost(), err)
+		}
+	}
+
+	// the lazy API version negotiation of the first request races with concurrent requests, e.g. of
+	// the event watchers. If the daemon is unreachable, the version is negotiated by the first request
+	negotiateCtx, cancel := context.WithTimeout(ctx, healthTimeout)
+	cli.NegotiateAPIVersion(negotiateCtx)
+	cancel()
+
+	c := &DockerCollector{
+		cli:                      cli,
+		metricPrefix:             cfg.MetricPrefix,
+		blockIoPerDevice:         cfg.BlockIoPerDevice,
+		labelPrefixFilter:        cfg.LabelPrefixFilter,
+		extraLabels:              newExtraLabels(cfg.ExtraLabels),
+		composeLabels:            cfg.ComposeLabels,
+		includeContainers:        cfg.IncludeContainers,
+		excludeContainers:        cfg.ExcludeContainers,
+		staleThreshold:           cfg.StaleThreshold,
+		execCountMetrics:         cfg.ExecCountMetrics,
+		samplingRate:             cfg.SamplingRate,
+		memoryLimitWarnThreshold: cfg.MemoryLimitWarnThreshold,
+		fdMetricsInterval:        cfg.FdMetricsInterval,
+		fsMetricsInterval:        cfg.FsMetricsInterval,
+		maxContainers:            cfg.MaxContainers,
+		scrapeConcurrency:        cfg.ScrapeConcurrency,
+		scrapeTimeout:            cfg.ScrapeTimeout,
+		cacheTTL:                 cfg.CacheTTL,
+		skippedContainers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "containers_skipped_total",
+			Help:      "Number of containers skipped because the maximal number of containers per scrape was reached",
+		}),
+		createdContainers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "container_created_total",
+			Help:      "Number of containers seen for the first time",
+		}),
+		removedContainers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "container_removed_total",
+			Help:      "Number of containers which disappeared",
+		}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "scrape_errors_total",
+			Help:      "Number of failed docker API calls while collecting the metrics of a container",
+		}, []string{"container_name", "error_type"}),
+		nameConflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "container_name_conflicts_total",
+			Help:      "Number of containers whose name collided with another container and got the short container ID appended",
+		}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "container_run_duration_seconds",
+			Help:      "Run duration of exited containers in seconds",
+			Buckets:   []float64{1, 10, 60, 300, 1800, 3600, 86400},
+		}, []string{"image_name", "exit_code"}),
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of a scrape including the docker API calls of all containers in seconds",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}),
+		scrapeContainers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "scrape_containers_total",
+			Help:      "Number of containers processed by the scrapes",
+		}),
+		imagePulls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "image_pull_total",
+			Help:      "Number of image pulls since dex started",
+		}, []string{"image_name"}),
+		imagePushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "image_push_total",
+			Help:      "Number of image pushes since dex started",
+		}, []string{"image_name"}),
+		lastImagePull: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.MetricPrefix,
+			Name:      "image_last_pull_timestamp_seconds",
+			Help:      "Unix timestamp of the last pull of the image since dex started",
+		}, []string{"image_name"}),
+	}
+
+	c.descs = newDescs(cfg.MetricPrefix, c.containerLabelNames())
+
+	c.addBuiltinCollectors(cfg)
+
+	go c.watchEvents(ctx, events.ContainerEventType, c.handleDieEvent, events.ActionDie)
+
+	go c.watchEvents(ctx, events.ImageEventType, c.handleImageEvent, events.ActionPull, events.ActionPush)
+
+	if cfg.MemoryPressureEvents {
+		c.enableMemoryPressureEvents(ctx)
+	}
+
+	return c
+}
+
+// verifyTLSConnection returns an error if the TLS handshake with the docker daemon fails, e.g.
+// because of invalid certificates
+func verifyTLSConnection(cli *client.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	_, err := cli.Ping(ctx)
+
+	return err
+}
+
+// AddCollector appends a stage to the metric collection for running containers.
+// It must not be called after the collector has been registered. The descriptors of the metrics
+// must be created with newDesc before the collector, so they are described with the container labels
+func (c *DockerCollector) AddCollector(mc MetricCollector) {
+	c.collectors = append(c.collectors, mc)
+}
+
+func (c *DockerCollector) addBuiltinCollectors(cfg DexConfig) {
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.blockIoMetrics(ch, d.Stats, d.Name)
+		c.blockIoWaitMetrics(ch, d.Stats, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.memoryMetrics(ch, d.Stats, d.Inspect.HostConfig, d.Scrape.MemTotal, d.Name)
+		c.memoryLimitNearMetrics(ch, d.Stats, d.ID, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.networkMetrics(ch, d.Stats, d.Inspect.HostConfig, d.Name)
+		c.interfaceMetrics(ch, d.Stats, d.ID, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.CPUMetrics(ch, d.Stats, d.Inspect.HostConfig, d.Scrape.NCPU, d.Name)
+		c.cpuWeightMetrics(ch, d.Inspect.HostConfig, d.Scrape, d.Name)
+		c.loadAverageMetrics(ch, d.Stats, d.ID, d.Name)
+		c.cpuBurstMetrics(ch, d.Stats, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.pidsMetrics(ch, d.Stats, d.Inspect.HostConfig, d.Name)
+		c.topMetrics(d.Ctx, ch, d.ID, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.blkioLimitMetrics(ch, d.Inspect.HostConfig, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.cgroupParentMetrics(ch, d.Inspect.HostConfig, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.securityMetrics(ch, d.Inspect.HostConfig, d.Scrape.SecurityOptions, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		if d.Inspect.Config != nil {
+			c.kubernetesMetrics(ch, d.Inspect.Config.Labels, d.Name)
+		}
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.storageDriverMetrics(ch, d.Inspect.GraphDriver.Name, d.Name)
+	}))
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.imageMetrics(d.Ctx, ch, d.Inspect.Image, d.Scrape, d.Name)
+	}))
+
+	if cfg.CPUHistogram {
+		c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+			c.cpuHistogramMetrics(ch, d.Stats, d.Name)
+		}))
+	}
+
+	if cfg.ThreadMetrics {
+		c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+			c.threadMetrics(d.Ctx, ch, d.ID, d.Name)
+		}))
+	}
+
+	if cfg.FdMetrics {
+		c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+			c.fdMetrics(d.Ctx, ch, d.ID, d.Name)
+		}))
+	}
+
+	if cfg.TcpMetrics {
+		c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+			c.tcpMetrics(d.Ctx, ch, d.ID, d.Name)
+		}))
+	}
+
+	if cfg.FsMetrics {
+		c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+			c.fsMetrics(d.Ctx, ch, d.ID, d.Name)
+		}))
+	}
+}
+
+// Describe sends the descriptors of all metrics. The container labels are fixed by the configuration,
+// so they are part of the descriptors
+func (c *DockerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+
+	for _, collector := range []prometheus.Collector{
+		c.skippedContainers, c.createdContainers, c.removedContainers, c.scrapeErrors, c.nameConflicts,
+		c.runDuration, c.imagePulls, c.imagePushes, c.lastImagePull, c.scrapeDuration, c.scrapeContainers,
+	} {
+		collector.Describe(ch)
+	}
+}
+
+func (c *DockerCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.cacheTTL == 0 {
+		c.scrape(ch)
+		return
+	}
+
+	metrics, scraped := c.cachedScrape()
+	for _, metric := range metrics {
+		ch <- metric
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.desc(cacheAgeSecondsDesc), prometheus.GaugeValue, time.Since(scraped).Seconds())
+}
+
+// scrape emits the metrics of all containers and the metrics of the scrape itself
+func (c *DockerCollector) scrape(ch chan<- prometheus.Metric) {
+	start := time.Now()
+
+	c.scrapeContainers.Add(float64(c.collectContainers(ch)))
+
+	c.scrapeDuration.Observe(time.Since(start).Seconds())
+
+	ch <- c.scrapeContainers
+	ch <- c.scrapeDuration
+}
+
+// collectContainers emits the metrics of all containers and returns the number of processed containers
+func (c *DockerCollector) collectContainers(ch chan<- prometheus.Metric) int {
+	ctx, cancel := context.WithTimeout(context.Background(), c.scrapeTimeout)
+	defer cancel()
+
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+	})
+	if err != nil {
+		log.Error("can't list containers: ", err)
+		return 0
+	}
+
+	c.countCreatedAndRemoved(containers)
+
+	ch <- c.createdContainers
+	ch <- c.removedContainers
+
+	c.forgetRemovedContainers(containers)
+
+	c.hostMetrics(ch, containers)
+
+	c.daemonMetrics(ch, containers)
+
+	var filtered []types.Container
+
+	for _, cont := range containers {
+		if c.matchesLabelPrefixFilter(cont) && c.matchesNameFilter(cont) {
+			filtered = append(filtered, cont)
+		}
+	}
+
+	// oldest containers first, so the most recently created ones are skipped when the limit is hit
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].Created != filtered[j].Created {
+			return filtered[i].Created < filtered[j].Created
+		}
+
+		return filtered[i].ID < filtered[j].ID
+	})
+
+	if c.maxContainers > 0 && len(filtered) > c.maxContainers {
+		c.skippedContainers.Add(float64(len(filtered) - c.maxContainers))
+		filtered = filtered[:c.maxContainers]
+	}
+
+	ch <- c.skippedContainers
+
+	// host information shared by all containers of this scrape
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		log.Error("can't get docker info: ", err)
+	}
+
+	scrape := scrapeInfo{Info: info}
+
+	// limits the concurrent docker API calls
+	workers := make(chan struct{}, c.scrapeConcurrency)
+
+	inspects := c.inspectContainers(ctx, filtered, workers)
+
+	// pre-aggregation over all running containers
+	for _, cont := range filtered {
+		if inspect, ok := inspects[cont.ID]; ok && cont.State == "running" {
+			scrape.runningContainers++
+
+			if inspect.HostConfig != nil && inspect.HostConfig.CPUShares > 0 {
+				scrape.totalCPUShares += inspect.HostConfig.CPUShares
+			}
+		}
+	}
+
+	names := c.containerNames(filtered)
+
+	ch <- c.nameConflicts
+
+	c.imagePulls.Collect(ch)
+	c.imagePushes.Collect(ch)
+	c.lastImagePull.Collect(ch)
+
+	ch <- prometheus.MustNewConstMetric(c.desc(containerSamplingRateDesc), prometheus.GaugeValue, c.samplingRate)
+
+	scrapeSecond := time.Now().Unix()
+
+	var wg sync.WaitGroup
+
+	processed := 0
+
+	for _, cont := range filtered {
+		inspect, ok := inspects[cont.ID]
+		if !ok {
+			continue
+		}
+
+		processed++
+
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(cont types.Container, inspect types.ContainerJSON) {
+			defer func() { <-workers }()
+
+			c.processContainer(ctx, cont, names[cont.ID], inspect, c.isSampled(cont.ID, scrapeSecond), &scrape, ch, &wg)
+		}(cont, inspect)
+	}
+	wg.Wait()
+
+	c.forgetScrapeErrors(names)
+	c.scrapeErrors.Collect(ch)
+
+	c.runDuration.Collect(ch)
+
+	return processed
+}
+
+// desc returns the descriptor of the template with the metric prefix of the collector
+func (c *DockerCollector) desc(template *prometheus.Desc) *prometheus.Desc {
+	if desc, ok := c.descs[template]; ok {
+		return desc
+	}
+
+	return template
+}
+
+// countScrapeError counts a failed docker API call while collecting the metrics of a container
+func (c *DockerCollector) countScrapeError(cName string, errorType string) {
+	c.scrapeErrorNames.Store(cName, struct{}{})
+	c.scrapeErrors.WithLabelValues(cName, errorType).Inc()
+}
+
+// scrapeErrorType returns "timeout" if the docker API call failed because ctx expired, errorType otherwise
+func scrapeErrorType(ctx context.Context, errorType string) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timeout"
+	}
+
+	return errorType
+}
+
+// countExecTimeout counts a command executed inside a container which failed because the scrape
+// timed out. Other failures are expected for containers without the command and not counted
+func (c *DockerCollector) countExecTimeout(ctx context.Context, cName string) {
+	if errorType := scrapeErrorType(ctx, "exec"); errorType == "timeout" {
+		c.countScrapeError(cName, errorType)
+	}
+}
+
+// forgetScrapeErrors drops the scrape errors of containers which are no longer collected
+func (c *DockerCollector) forgetScrapeErrors(names map[string]string) {
+	current := make(map[string]bool, len(names))
+	for _, cName := range names {
+		current[cName] = true
+	}
+
+	c.scrapeErrorNames.Range(func(cName, _ any) bool {
+		if !current[cName.(string)] {
+			c.scrapeErrorNames.Delete(cName)
+			c.scrapeErrors.DeletePartialMatch(prometheus.Labels{"container_name": cName.(string)})
+		}
+
+		return true
+	})
+}
+
+// hostMetrics emits the number of containers of the docker host by state. All containers are
+// counted regardless of the filters, so the metrics are present even if no container is running
+func (c *DockerCollector) hostMetrics(ch chan<- prometheus.Metric, containers []types.Container) {
+	var running, stopped, paused int
+
+	for _, cont := range containers {
+		switch cont.State {
+		case "running":
+			running++
+		case "paused":
+			paused++
+		case "created", "exited", "dead":
+			stopped++
+		}
+	}
+
+	for _, m := range []struct {
+		desc  *prometheus.Desc
+		value int
+	}{
+		{hostContainersTotalDesc, len(containers)},
+		{hostContainersRunningDesc, running},
+		{hostContainersStoppedDesc, stopped},
+		{hostContainersPausedDesc, paused},
+	} {
+		ch <- prometheus.MustNewConstMetric(c.desc(m.desc), prometheus.GaugeValue, float64(m.value))
+	}
+}
+
+// daemonMetrics emits aggregates over all containers of the docker host regardless of the filters
+func (c *DockerCollector) daemonMetrics(ch chan<- prometheus.Metric, containers []types.Container) {
+	var hostNetwork int
+
+	for _, cont := range containers {
+		if cont.State == "running" && container.NetworkMode(cont.HostConfig.NetworkMode).IsHost() {
+			hostNetwork++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.desc(dockerContainersHostNetworkTotalDesc), prometheus.GaugeValue, float64(hostNetwork))
+}
+
+// isSampled decides whether the stats of a container are collected in this scrape. The decision is
+// derived from the container ID and the second of the scrape, so it is the same for scrapes in the same second
+func (c *DockerCollector) isSampled(containerID string, scrapeSecond int64) bool {
+	if c.samplingRate >= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(containerID))
+	_, _ = h.Write([]byte(strconv.FormatInt(scrapeSecond, 10)))
+
+	return float64(h.Sum64())/math.MaxUint64 < c.samplingRate
+}
+
+// containerNames returns the container_name label value per container ID. Names which only differ
+// in case are ambiguous, the short container ID is appended to all but the first (oldest) container
+func (c *DockerCollector) containerNames(containers []types.Container) map[string]string {
+	names := make(map[string]string, len(containers))
+	seen := make(map[string]bool, len(containers))
+
+	for _, cont := range containers {
+		cName := containerName(cont)
+
+		if normalized := strings.ToLower(cName); seen[normalized] {
+			// the conflict persists over the scrapes, but each container is counted once
+			if _, counted := c.nameConflictIDs.LoadOrStore(cont.ID, struct{}{}); !counted {
+				c.nameConflicts.Inc()
+			}
+
+			cName += "_" + shortID(cont.ID)
+		} else {
+			seen[normalized] = true
+		}
+
+		names[cont.ID] = cName
+	}
+
+	return names
+}
+
+// shortID returns the abbreviated container ID as shown by the docker CLI
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
+
+// countCreatedAndRemoved counts the containers which were not seen before and the containers which disappeared
+func (c *DockerCollector) countCreatedAndRemoved(containers []types.Container) {
+	ids := make(map[string]bool, len(containers))
+	for _, cont := range containers {
+		ids[cont.ID] = true
+
+		if _, seen := c.seenContainers.LoadOrStore(cont.ID, struct{}{}); !seen {
+			c.createdContainers.Inc()
+		}
+	}
+
+	c.seenContainers.Range(func(id, _ any) bool {
+		if !ids[id.(string)] {
+			c.seenContainers.Delete(id)
+			c.removedContainers.Inc()
+		}
+
+		return true
+	})
+}
+
+// containerStates returns the state kept between scrapes per container ID
+func (c *DockerCollector) containerStates() []*sync.Map {
+	return []*sync.Map{&c.prevThrottling, &c.lastStatsTime, &c.prevInterfaces, &c.fdSamples, &c.fsSamples, &c.observedRuns, &c.dieEvents, &c.memoryLimitNear, &c.oomEvents, &c.healthFailures, &c.nameConflictIDs}
+}
+
+// Reset drops the state kept between scrapes and the scrape errors, so the next scrape behaves like
+// the first one, e.g. all containers are counted as created again. The counters of created, removed,
+// skipped and conflicting containers keep their values. It is safe to call concurrently with Collect
+func (c *DockerCollector) Reset() {
+	for _, state := range append(c.containerStates(), &c.seenContainers, &c.scrapeErrorNames) {
+		state.Range(func(key, _ any) bool {
+			state.Delete(key)
+
+			return true
+		})
+	}
+
+	// observed runs are recorded again
+	c.runDuration.Reset()
+	c.scrapeErrors.Reset()
+
+	c.resetCache()
+}
+
+// forgetRemovedContainers drops the state kept between scrapes for containers which no longer exist
+func (c *DockerCollector) forgetRemovedContainers(containers []types.Container) {
+	ids := make(map[string]bool, len(containers))
+	for _, cont := range containers {
+		ids[cont.ID] = true
+	}
+
+	for _, state := range c.containerStates() {
+		state.Range(func(id, _ any) bool {
+			if !ids[id.(string)] {
+				state.Delete(id)
+			}
+
+			return true
+		})
+	}
+}
+
+// a container which can't be inspected within this time is skipped, so it doesn't stall the scrape
+const inspectTimeout = 5 * time.Second
+
+// inspectContainers inspects the containers concurrently, at most as many as workers can hold at once.
+// Containers which can't be inspected, e.g. because they were removed in the meantime, are missing in the result
+func (c *DockerCollector) inspectContainers(ctx context.Context, containers []types.Container, workers chan struct{}) map[string]types.ContainerJSON {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	inspects := make(map[string]types.ContainerJSON, len(containers))
+
+	for _, cont := range containers {
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(id string, cName string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			ctx, cancel := context.WithTimeout(ctx, inspectTimeout)
+			defer cancel()
+
+			ctx, span := startAPISpan(ctx, "ContainerInspect", cName)
+			defer span.End()
+
+			inspect, err := c.cli.ContainerInspect(ctx, id)
+			if err != nil {
+				log.Error("can't inspect container: ", err)
+				c.countScrapeError(cName, scrapeErrorType(ctx, "inspect"))
+
+				return
+			}
+
+			mu.Lock()
+			inspects[id] = inspect
+			mu.Unlock()
+		}(cont.ID, containerName(cont))
+	}
+	wg.Wait()
+
+	return inspects
+}
+
+// matchesNameFilter checks the container name against the include and exclude patterns. The include
+// pattern is an allowlist, containers matching it are collected even if they match the exclude pattern
+func (c *DockerCollector) matchesNameFilter(cont types.Container) bool {
+	name := containerName(cont)
+
+	if c.includeContainers != nil {
+		return c.includeContainers.MatchString(name)
+	}
+
+	return c.excludeContainers == nil || !c.excludeContainers.MatchString(name)
+}
+
+// matchesLabelPrefixFilter returns true if the container has a label key starting with
+// one of the configured prefixes or if no prefix filter is configured
+func (c *DockerCollector) matchesLabelPrefixFilter(cont types.Container) bool {
+	if len(c.labelPrefixFilter) == 0 {
+		return true
+	}
+
+	for key := range cont.Labels {
+		for _, prefix := range c.labelPrefixFilter {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// containerName returns the value of the container_name label
+func containerName(cont types.Container) string {
+	return strings.TrimPrefix(strings.Join(cont.Names, ";"), "/")
+}
+
+func (c *DockerCollector) processContainer(ctx context.Context, cont types.Container, cName string, inspect types.ContainerJSON, sampled bool, scrape *scrapeInfo, ch chan<- prometheus.Metric, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ctx, span := startSpan(ctx, "processContainer", cName)
+	defer span.End()
+
+	ch, done := c.withLabelPairs(ch, c.containerLabelPairs(cont, scrape.Swarm.LocalNodeState == swarm.LocalNodeStateActive))
+	defer done()
+
+	var isRunning, isRestarting, isExited float64
+
+	if cont.State == "running" {
+		isRunning = 1
+	}
+
+	if cont.State == "restarting" {
+		isRestarting = 1
+	}
+
+	if cont.State == "exited" {
+		isExited = 1
+	}
+
+	// container state metric for all containers
+	ch <- prometheus.MustNewConstMetric(containerRunningDesc, prometheus.GaugeValue, isRunning, cName)
+
+	ch <- prometheus.MustNewConstMetric(containerRestartingDesc, prometheus.GaugeValue, isRestarting, cName)
+
+	ch <- prometheus.MustNewConstMetric(containerExitedDesc, prometheus.GaugeValue, isExited, cName)
+
+	// raw docker state (created, restarting, running, removing, paused, exited or dead)
+	ch <- prometheus.MustNewConstMetric(containerStateDesc, prometheus.GaugeValue, 1, cName, cont.State)
+
+	ch <- prometheus.MustNewConstMetric(containerRestartsTotalDesc, prometheus.CounterValue, float64(inspect.RestartCount), cName)
+
+	var exitCode int
+	if isRunning == 0 && inspect.State != nil {
+		exitCode = inspect.State.ExitCode
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerExitCodeDesc, prometheus.GaugeValue, float64(exitCode), cName)
+
+	c.attachMetrics(ch, inspect.Config, cName)
+
+	c.stopMetrics(ch, inspect.Config, cName)
+
+	c.healthLogMetrics(ch, inspect.State, cont.ID, cName)
+
+	c.healthStatusMetrics(ch, inspect.State, cName)
+
+	c.imageTagMetrics(ch, cont.Image, cName)
+
+	c.dieMetrics(ch, cont.ID, cName)
+
+	c.oomEventsMetrics(ch, cont.ID, cName)
+
+	c.observeRunDuration(cont, inspect.State)
+
+	c.startMetrics(ch, inspect.State, cName)
+
+	// stats metrics only for running containers, which are sampled in this scrape
+	if isRunning == 1 && sampled {
+		statsCtx, statsSpan := startAPISpan(ctx, "ContainerStats", cName)
+		stats, err := c.cli.ContainerStats(statsCtx, cont.ID, false)
+		if err != nil {
+			statsSpan.End()
+
+			// the container may have been removed since it was listed
+			log.Error("can't get api stats: ", err)
+			c.countScrapeError(cName, scrapeErrorType(ctx, "stats"))
+			c.staleMetrics(ch, cont.ID, false, cName)
+
+			return
+		}
+
+		var containerStats container.StatsResponse
+		err = json.NewDecoder(stats.Body).Decode(&containerStats)
+		if closeErr := stats.Body.Close(); closeErr != nil {
+			log.Error("can't close body: ", closeErr)
+		}
+		statsSpan.End()
+
+		c.staleMetrics(ch, cont.ID, err == nil, cName)
+
+		if err != nil {
+			log.Error("can't read api stats: ", err)
+			c.countScrapeError(cName, scrapeErrorType(ctx, "stats_decode"))
+
+			return
+		}
+
+		data := &ContainerData{
+			Ctx:     ctx,
+			ID:      cont.ID,
+			Name:    cName,
+			Inspect: &inspect,
+			Stats:   &containerStats,
+			Scrape:  scrape,
+		}
+
+		for i, mc := range c.collectors {
+			_, collectorSpan := startSpan(ctx, "MetricCollector", cName, attribute.Int("dex.collector", i))
+			mc.Collect(ch, data)
+			collectorSpan.End()
+		}
+	}
+}
+
+// staleMetrics flags running containers without successful stats for longer than the stale threshold
+func (c *DockerCollector) staleMetrics(ch chan<- prometheus.Metric, containerID string, statsOk bool, cName string) {
+	now := time.Now()
+
+	if statsOk {
+		c.lastStatsTime.Store(containerID, now)
+	}
+
+	// if stats never succeeded, the threshold starts with the first failure
+	lastStats, _ := c.lastStatsTime.LoadOrStore(containerID, now)
+
+	var isStale float64
+	if now.Sub(lastStats.(time.Time)) > c.staleThreshold {
+		isStale = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerStaleDesc, prometheus.GaugeValue, isStale, cName)
+}
+
+func (c *DockerCollector) CPUMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, hostConfig *container.HostConfig, hostCPUs int, cName string) {
+	totalUsage := containerStats.CPUStats.CPUUsage.TotalUsage
+	cpuDelta := totalUsage - containerStats.PreCPUStats.CPUUsage.TotalUsage
+	sysemDelta := containerStats.CPUStats.SystemUsage - containerStats.PreCPUStats.SystemUsage
+
+	// the system usage is the sum over all host CPUs, so the utilization is already in the range of 0 to 100
+	var cpuUtilization float64
+	if sysemDelta > 0 {
+		cpuUtilization = float64(cpuDelta) / float64(sysemDelta) * 100.0
+	}
+
+	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentDesc, prometheus.GaugeValue, cpuUtilization, cName)
+
+	ch <- prometheus.MustNewConstMetric(cpuCountDesc, prometheus.GaugeValue, float64(onlineCPUs(containerStats)), cName)
+
+	ch <- prometheus.MustNewConstMetric(cpuUtilizationSecondsTotalDesc, prometheus.CounterValue, float64(totalUsage)/1e9, cName)
+
+	// CFS throttling of containers with a CPU limit, throttled time is reported in nanoseconds
+	throttling := containerStats.CPUStats.ThrottlingData
+	ch <- prometheus.MustNewConstMetric(cpuThrottlePeriodsTotalDesc, prometheus.CounterValue, float64(throttling.Periods), cName)
+	ch <- prometheus.MustNewConstMetric(cpuThrottledPeriodsTotalDesc, prometheus.CounterValue, float64(throttling.ThrottledPeriods), cName)
+	ch <- prometheus.MustNewConstMetric(cpuThrottledSecondsTotalDesc, prometheus.CounterValue, float64(throttling.ThrottledTime)/1e9, cName)
+
+	// deltas between the current and the previous stats snapshot used for the utilization
+	ch <- prometheus.MustNewConstMetric(containerCPUUsageNanosecondsDeltaDesc, prometheus.GaugeValue, float64(cpuDelta), cName)
+
+	ch <- prometheus.MustNewConstMetric(containerCPUSystemNanosecondsDeltaDesc, prometheus.GaugeValue, float64(sysemDelta), cName)
+
+	ch <- prometheus.MustNewConstMetric(containerCPUQuotaRatioDesc, prometheus.GaugeValue, cpuLimit(hostConfig), cName)
+
+	if hostConfig != nil {
+		ch <- prometheus.MustNewConstMetric(containerCPUPeriodSecondsDesc, prometheus.GaugeValue, float64(cpuPeriod(hostConfig))/1e6, cName)
+
+		quota := math.Inf(1)
+		if limit := cpuLimit(hostConfig); limit > 0 {
+			quota = limit * 1e9
+		}
+
+		ch <- prometheus.MustNewConstMetric(cpuQuotaNanocpusDesc, prometheus.GaugeValue, quota, cName)
+
+		ch <- prometheus.MustNewConstMetric(cpuPeriodMicrosecondsDesc, prometheus.GaugeValue, float64(cpuPeriod(hostConfig)), cName)
+	}
+
+	// per CPU usage is only reported with cgroups v1, burst periods only with cgroups v2
+	cgroupV2 := -1.0
+	switch {
+	case len(containerStats.CPUStats.CPUUsage.PercpuUsage) > 0:
+		cgroupV2 = 0
+	case burstPeriods(containerStats) > 0 || totalUsage > 0:
+		cgroupV2 = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerCPUCgroupV2Desc, prometheus.GaugeValue, cgroupV2, cName)
+
+	// limit as share of the host capacity, comparable with dex_cpu_utilization_percent
+	if limit := cpuLimit(hostConfig); limit > 0 && hostCPUs > 0 {
+		ch <- prometheus.MustNewConstMetric(containerCPUPercentLimitDesc, prometheus.GaugeValue, limit/float64(hostCPUs)*100.0, cName)
+	}
+}
+
+// cpuWeightMetrics emits the relative CPU priority of the container: the CPU limit as share of the host CPUs,
+// the CPU shares as share of all running containers' shares or an equal share if neither is configured
+func (c *DockerCollector) cpuWeightMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, scrape *scrapeInfo, cName string) {
+	var weight float64
+
+	switch {
+	case hostConfig != nil && hostConfig.NanoCPUs > 0:
+		if scrape.NCPU == 0 {
+			return
+		}
+
+		weight = float64(hostConfig.NanoCPUs) / 1e9 / float64(scrape.NCPU)
+	case hostConfig != nil && hostConfig.CPUShares > 0:
+		weight = float64(hostConfig.CPUShares) / float64(scrape.totalCPUShares)
+	default:
+		weight = 1.0 / float64(scrape.runningContainers)
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerCPUWeightDesc, prometheus.GaugeValue, weight, cName)
+}
+
+// loadAverageMetrics emits an estimate of the CPU demand in CPUs exceeding the quota: the share of
+// throttled CFS periods since the previous scrape scaled by the online CPUs, exponentially smoothed
+// with a time constant of 10 seconds. Unthrottled containers have a load of 0.
+func (c *DockerCollector) loadAverageMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, containerID string, cName string) {
+	throttling := containerStats.CPUStats.ThrottlingData
+
+	current := throttlingSample{
+		read:             containerStats.Read,
+		periods:          throttling.Periods,
+		throttledPeriods: throttling.ThrottledPeriods,
+	}
+
+	if prev, ok := c.prevThrottling.Load(containerID); ok {
+		prev := prev.(throttlingSample)
+		elapsed := current.read.Sub(prev.read).Seconds()
+
+		// counters are reset if the container restarted
+		if elapsed > 0 && current.periods >= prev.periods && current.throttledPeriods >= prev.throttledPeriods {
+			var demand float64
+			if periods := current.periods - prev.periods; periods > 0 {
+				demand = float64(current.throttledPeriods-prev.throttledPeriods) / float64(periods) * float64(onlineCPUs(containerStats))
+			}
+
+			current.load = prev.load + (1-math.Exp(-elapsed/10))*(demand-prev.load)
+		}
+	}
+
+	c.prevThrottling.Store(containerID, current)
+
+	ch <- prometheus.MustNewConstMetric(containerCPULoadAverage10sDesc, prometheus.GaugeValue, current.load, cName)
+}
+
+// onlineCPUs returns the number of CPUs available to the container, older daemons
+// don't report online CPUs
+func onlineCPUs(containerStats *container.StatsResponse) uint32 {
+	if containerStats.CPUStats.OnlineCPUs > 0 {
+		return containerStats.CPUStats.OnlineCPUs
+	}
+
+	return uint32(len(containerStats.CPUStats.CPUUsage.PercpuUsage))
+}
+
+// cpuLimit returns the number of CPUs the container is limited to, 0 if unlimited
+func cpuLimit(hostConfig *container.HostConfig) float64 {
+	if hostConfig == nil {
+		return 0
+	}
+
+	// --cpus is stored as NanoCPUs and not as quota
+	if hostConfig.NanoCPUs > 0 {
+		return float64(hostConfig.NanoCPUs) / 1e9
+	}
+
+	if hostConfig.CPUQuota <= 0 {
+		return 0
+	}
+
+	return float64(hostConfig.CPUQuota) / float64(cpuPeriod(hostConfig))
+}
+
+// cpuPeriod returns the CFS period of the container in microseconds
+func cpuPeriod(hostConfig *container.HostConfig) int64 {
+	// kernel default CFS period is 100ms
+	if hostConfig.CPUPeriod <= 0 {
+		return 100000
+	}
+
+	return hostConfig.CPUPeriod
+}
+
+func (c *DockerCollector) networkMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, hostConfig *container.HostConfig, cName string) {
+	// containers with host or no networking have no network stats
+	if len(containerStats.Networks) == 0 {
+		reason := "unknown"
+		if hostConfig != nil {
+			if hostConfig.NetworkMode.IsHost() {
+				reason = "host_network"
+			} else if hostConfig.NetworkMode.IsNone() {
+				reason = "no_network"
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(containerNetworkStatsMissingDesc, prometheus.GaugeValue, 1, cName, reason)
+	}
+
+	for iface, n := range containerStats.Networks {
+		ch <- prometheus.MustNewConstMetric(networkRxBytesTotalDesc, prometheus.CounterValue, float64(n.RxBytes), cName, iface)
+		ch <- prometheus.MustNewConstMetric(networkTxBytesTotalDesc, prometheus.CounterValue, float64(n.TxBytes), cName, iface)
+
+		// emitted even if zero, errors and drops are rare
+		for _, counter := range []struct {
+			desc  *prometheus.Desc
+			value uint64
+		}{
+			{networkRxPacketsTotalDesc, n.RxPackets},
+			{networkTxPacketsTotalDesc, n.TxPackets},
+			{networkRxErrorsTotalDesc, n.RxErrors},
+			{networkTxErrorsTotalDesc, n.TxErrors},
+			{networkRxDroppedTotalDesc, n.RxDropped},
+			{networkTxDroppedTotalDesc, n.TxDropped},
+		} {
+			ch <- prometheus.MustNewConstMetric(counter.desc, prometheus.CounterValue, float64(counter.value), cName, iface)
+		}
+	}
+
+	// sum over all interfaces, zero if the container has no network stats
+	var rxTotal, txTotal uint64
+	for _, n := range containerStats.Networks {
+		rxTotal += n.RxBytes
+		txTotal += n.TxBytes
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerNetworkTotalRxBytesTotalDesc, prometheus.CounterValue, float64(rxTotal), cName)
+	ch <- prometheus.MustNewConstMetric(containerNetworkTotalTxBytesTotalDesc, prometheus.CounterValue, float64(txTotal), cName)
+}
+
+// interfaceMetrics emits the number of network interfaces of the container and counts the changes
+// of this number between scrapes, e.g. when the container was disconnected from a network
+func (c *DockerCollector) interfaceMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, containerID string, cName string) {
+	sample := interfaceSample{interfaces: len(containerStats.Networks)}
+
+	if prev, ok := c.prevInterfaces.Load(containerID); ok {
+		prevSample := prev.(interfaceSample)
+		sample.changes = prevSample.changes
+
+		if prevSample.interfaces != sample.interfaces {
+			sample.changes++
+		}
+	}
+
+	c.prevInterfaces.Store(containerID, sample)
+
+	ch <- prometheus.MustNewConstMetric(containerNetworkInterfaceCountDesc, prometheus.GaugeValue, float64(sample.interfaces), cName)
+
+	ch <- prometheus.MustNewConstMetric(containerNetworkTopologyChangeTotalDesc, prometheus.CounterValue, float64(sample.changes), cName)
+}
+
+// cgroups v1 limits from this value on are unlimited (math.MaxInt64 rounded down to the page size)
+const unlimitedCgroupLimit = math.MaxInt64 &^ 0xfff
+
+// swapLimit returns the configured swap limit. MemorySwap is the memory + swap limit, -1 for unlimited
+// swap. If it is not set, the container may use as much swap as memory and unlimited swap without a
+// memory limit
+func swapLimit(hostConfig *container.HostConfig) float64 {
+	switch {
+	case hostConfig.MemorySwap == -1:
+		return math.Inf(1)
+	case hostConfig.MemorySwap == 0 && hostConfig.Memory > 0:
+		return float64(hostConfig.Memory)
+	case hostConfig.MemorySwap == 0:
+		return math.Inf(1)
+	case hostConfig.MemorySwap > hostConfig.Memory:
+		return float64(hostConfig.MemorySwap - hostConfig.Memory)
+	default:
+		// disabled with MemorySwap == Memory
+		return 0
+	}
+}
+
+// effectiveMemoryUsage returns the memory usage without the page cache. cgroups v2 reports the
+// reclaimable page cache as inactive_file, cgroups v1 as cache
+func effectiveMemoryUsage(stats container.MemoryStats) uint64 {
+	cache, ok := stats.Stats["inactive_file"]
+	if !ok {
+		cache, ok = stats.Stats["cache"]
+	}
+
+	if !ok || cache > stats.Usage {
+		return stats.Usage
+	}
+
+	return stats.Usage - cache
+}
+
+func (c *DockerCollector) memoryMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, hostConfig *container.HostConfig, hostMemory int64, cName string) {
+	// From official documentation
+	//Note: On Linux, the Docker CLI reports memory usage by subtracting page cache usage from the total memory usage.
+	//The API does not perform such a calculation but rather provides the total memory usage and the amount from the page cache so that clients can use the data as needed.
+	memoryUsage := effectiveMemoryUsage(containerStats.MemoryStats)
+	memoryTotal := containerStats.MemoryStats.Limit
+
+	memoryUtilization := float64(memoryUsage) / float64(memoryTotal) * 100.0
+	ch <- prometheus.MustNewConstMetric(memoryUsageBytesDesc, prometheus.CounterValue, float64(memoryUsage), cName)
+	ch <- prometheus.MustNewConstMetric(memoryTotalBytesDesc, prometheus.GaugeValue, float64(memoryTotal), cName)
+	ch <- prometheus.MustNewConstMetric(memoryUtilizationPercentDesc, prometheus.GaugeValue, memoryUtilization, cName)
+
+	// soft limit (--memory-reservation), omitted if not configured
+	if hostConfig != nil && hostConfig.MemoryReservation > 0 {
+		ch <- prometheus.MustNewConstMetric(containerMemoryLimitSoftBytesDesc, prometheus.GaugeValue, float64(hostConfig.MemoryReservation), cName)
+	}
+
+	// hard limit as share of the host memory, 0 if unlimited
+	if hostConfig != nil && hostConfig.Memory > 0 && hostMemory > 0 {
+		ch <- prometheus.MustNewConstMetric(containerMemoryPercentLimitDesc, prometheus.GaugeValue, float64(hostConfig.Memory)/float64(hostMemory)*100.0, cName)
+	}
+
+	// processes with higher values are killed first by the OOM killer, 0 is meaningful
+	if hostConfig != nil {
+		ch <- prometheus.MustNewConstMetric(containerOomScoreAdjDesc, prometheus.GaugeValue, float64(hostConfig.OomScoreAdj), cName)
+	}
+
+	// with disabled OOM killer exceeding the limit drives the whole host out of memory
+	if hostConfig != nil {
+		var oomKillDisabled float64
+		if hostConfig.OomKillDisable != nil && *hostConfig.OomKillDisable {
+			oomKillDisabled = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(containerOomKillDisableDesc, prometheus.GaugeValue, oomKillDisabled, cName)
+	}
+
+	if hostConfig != nil {
+		ch <- prometheus.MustNewConstMetric(containerSwapLimitBytesDesc, prometheus.GaugeValue, swapLimit(hostConfig), cName)
+	}
+
+	// the memory.stat keys differ between cgroup versions
+	cgroupVersion := "unknown"
+	if _, ok := containerStats.MemoryStats.Stats["anon"]; ok {
+		cgroupVersion = "2"
+	} else if _, ok := containerStats.MemoryStats.Stats["rss"]; ok {
+		cgroupVersion = "1"
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerCgroupVersionDesc, prometheus.GaugeValue, 1, cName, cgroupVersion)
+
+	// page faults, major faults required disk I/O (swap or demand paging from disk)
+	if pgfault, ok := containerStats.MemoryStats.Stats["pgfault"]; ok {
+		ch <- prometheus.MustNewConstMetric(containerMemoryPgfaultTotalDesc, prometheus.CounterValue, float64(pgfault), cName)
+	}
+	if pgmajfault, ok := containerStats.MemoryStats.Stats["pgmajfault"]; ok {
+		ch <- prometheus.MustNewConstMetric(containerMemoryPgmajfaultTotalDesc, prometheus.CounterValue, float64(pgmajfault), cName)
+	}
+
+	// only cgroups v1 with swap accounting
+	if swapFailcnt := containerStats.MemoryStats.Stats["memsw.failcnt"]; swapFailcnt > 0 {
+		ch <- prometheus.MustNewConstMetric(containerMemorySwapFailcntTotalDesc, prometheus.CounterValue, float64(swapFailcnt), cName)
+	}
+
+	// cgroups v2 reports the OOM kills, cgroups v1 only the number of times the limit was hit
+	oomKills := containerStats.MemoryStats.Stats["oom_kill"]
+	if oomKills == 0 {
+		oomKills = containerStats.MemoryStats.Failcnt
+	}
+
+	ch <- prometheus.MustNewConstMetric(memoryOomKillsTotalDesc, prometheus.CounterValue, float64(oomKills), cName)
+
+	// swap usage and memory + swap limit are only reported by cgroups v1 with swap accounting
+	if swapUsage, ok := containerStats.MemoryStats.Stats["swap"]; ok {
+		ch <- prometheus.MustNewConstMetric(memorySwapUsageBytesDesc, prometheus.GaugeValue, float64(swapUsage), cName)
+	}
+
+	memswLimit, hasMemswLimit := containerStats.MemoryStats.Stats["hierarchical_memsw_limit"]
+	memoryLimit, hasMemoryLimit := containerStats.MemoryStats.Stats["hierarchical_memory_limit"]
+	if hasMemswLimit && hasMemoryLimit {
+		// the kernel reports unlimited as the largest page aligned int64
+		swapLimit := math.Inf(1)
+		if memswLimit < unlimitedCgroupLimit {
+			swapLimit = float64(memswLimit) - float64(memoryLimit)
+		}
+
+		ch <- prometheus.MustNewConstMetric(memorySwapLimitBytesDesc, prometheus.GaugeValue, swapLimit, cName)
+	}
+
+	// kernel TCP buffer memory, only cgroups v1 with kernel memory accounting
+	if tcpBuffer, ok := containerStats.MemoryStats.Stats["tcp"]; ok {
+		ch <- prometheus.MustNewConstMetric(containerMemoryTcpBufferBytesDesc, prometheus.GaugeValue, float64(tcpBuffer), cName)
+	}
+}
+
+// memoryLimitNearMetrics counts the scrapes in which the memory usage of the container was near its limit
+func (c *DockerCollector) memoryLimitNearMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, containerID string, cName string) {
+	var near uint64
+	if prev, ok := c.memoryLimitNear.Load(containerID); ok {
+		near = prev.(uint64)
+	}
+
+	limit := containerStats.MemoryStats.Limit
+	if limit > 0 && float64(effectiveMemoryUsage(containerStats.MemoryStats)) >= float64(limit)*c.memoryLimitWarnThreshold {
+		near++
+		c.memoryLimitNear.Store(containerID, near)
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerMemoryLimitNearTotalDesc, prometheus.CounterValue, float64(near), cName)
+}
+
+func (c *DockerCollector) blockIoMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
+	var readTotal, writeTotal, discardTotal uint64
+	readPerDevice := make(map[string]uint64)
+	writePerDevice := make(map[string]uint64)
+	for _, b := range containerStats.BlkioStats.IoServiceBytesRecursive {
+		if strings.EqualFold(b.Op, "read") {
+			readTotal += b.Value
+			readPerDevice[fmt.Sprintf("%d:%d", b.Major, b.Minor)] += b.Value
+		}
+		if strings.EqualFold(b.Op, "write") {
+			writeTotal += b.Value
+			writePerDevice[fmt.Sprintf("%d:%d", b.Major, b.Minor)] += b.Value
+		}
+		if strings.EqualFold(b.Op, "discard") {
+			discardTotal += b.Value
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(blockIoReadBytesTotalDesc, prometheus.CounterValue, float64(readTotal), cName, "total")
+
+	ch <- prometheus.MustNewConstMetric(blockIoWriteBytesTotalDesc, prometheus.CounterValue, float64(writeTotal), cName, "total")
+
+	if c.blockIoPerDevice {
+		for device, read := range readPerDevice {
+			ch <- prometheus.MustNewConstMetric(blockIoReadBytesTotalDesc, prometheus.CounterValue, float64(read), cName, device)
+		}
+
+		for device, write := range writePerDevice {
+			ch <- prometheus.MustNewConstMetric(blockIoWriteBytesTotalDesc, prometheus.CounterValue, float64(write), cName, device)
+		}
+	}
+
+	// discard (TRIM) is only reported by devices supporting it
+	if discardTotal > 0 {
+		ch <- prometheus.MustNewConstMetric(containerBlockIoDiscardBytesTotalDesc, prometheus.CounterValue, float64(discardTotal), cName)
+	}
+
+	readOps, writeOps, discardOps := blkioOpTotals(containerStats.BlkioStats.IoServicedRecursive)
+
+	ch <- prometheus.MustNewConstMetric(blockIoReadOpsTotalDesc, prometheus.CounterValue, float64(readOps), cName)
+
+	ch <- prometheus.MustNewConstMetric(blockIoWriteOpsTotalDesc, prometheus.CounterValue, float64(writeOps), cName)
+
+	if discardOps > 0 {
+		ch <- prometheus.MustNewConstMetric(containerBlockIoDiscardOpsTotalDesc, prometheus.CounterValue, float64(discardOps), cName)
+	}
+}
+
+func (c *DockerCollector) blockIoWaitMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
+	var readWait, writeWait uint64
+	perDevice := make(map[string]uint64)
+	for _, b := range containerStats.BlkioStats.IoWaitTimeRecursive {
+		switch {
+		case strings.EqualFold(b.Op, "read"):
+			readWait += b.Value
+		case strings.EqualFold(b.Op, "write"):
+			writeWait += b.Value
+		default:
+			continue
+		}
+
+		perDevice[fmt.Sprintf("%d:%d", b.Major, b.Minor)] += b.Value
+	}
+
+	// wait time is reported in nanoseconds
+	ch <- prometheus.MustNewConstMetric(containerBlockIoWaitTimeSecondsTotalDesc, prometheus.CounterValue, float64(readWait+writeWait)/1e9, cName)
+
+	// average latency per operation since the container was started, 0 without operations
+	readOps, writeOps, _ := blkioOpTotals(containerStats.BlkioStats.IoServicedRecursive)
+
+	var readLatency, writeLatency float64
+	if readOps > 0 {
+		readLatency = float64(readWait) / float64(readOps) / 1e6
+	}
+	if writeOps > 0 {
+		writeLatency = float64(writeWait) / float64(writeOps) / 1e6
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerBlockIoReadAvgLatencyMsDesc, prometheus.GaugeValue, readLatency, cName)
+	ch <- prometheus.MustNewConstMetric(containerBlockIoWriteAvgLatencyMsDesc, prometheus.GaugeValue, writeLatency, cName)
+
+	if c.blockIoPerDevice {
+		for device, wait := range perDevice {
+			ch <- prometheus.MustNewConstMetric(containerBlockIoDeviceWaitTimeSecondsTotalDesc, prometheus.CounterValue, float64(wait)/1e9, cName, device)
+		}
+	}
+}
+
+// blkioOpTotals sums the entries of the blkio stats per operation over all devices
+func blkioOpTotals(entries []container.BlkioStatEntry) (read, write, discard uint64) {
+	for _, b := range entries {
+		switch {
+		case strings.EqualFold(b.Op, "read"):
+			read += b.Value
+		case strings.EqualFold(b.Op, "write"):
+			write += b.Value
+		case strings.EqualFold(b.Op, "discard"):
+			discard += b.Value
+		}
+	}
+
+	return read, write, discard
+}
+
+func (c *DockerCollector) pidsMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, hostConfig *container.HostConfig, cName string) {
+	ch <- prometheus.MustNewConstMetric(pidsCurrentDesc, prometheus.CounterValue, float64(containerStats.PidsStats.Current), cName)
+
+	// -1 or 0 means unlimited, exposed as 0
+	var pidsMax float64
+	if hostConfig != nil && hostConfig.PidsLimit != nil && *hostConfig.PidsLimit > 0 {
+		pidsMax = float64(*hostConfig.PidsLimit)
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerPidsMaxDesc, prometheus.GaugeValue, pidsMax, cName)
+
+	// limit reported by the cgroup, 0 means unlimited
+	pidsLimit := math.Inf(1)
+	if containerStats.PidsStats.Limit > 0 {
+		pidsLimit = float64(containerStats.PidsStats.Limit)
+	}
+
+	ch <- prometheus.MustNewConstMetric(pidsLimitDesc, prometheus.GaugeValue, pidsLimit, cName)
+}
+
+func (c *DockerCollector) topMetrics(ctx context.Context, ch chan<- prometheus.Metric, containerID string, cName string) {
+	top, err := c.cli.ContainerTop(ctx, containerID, []string{})
+	if err != nil {
+		log.Error("can't list container processes: ", err)
+		c.countScrapeError(cName, scrapeErrorType(ctx, "top"))
+
+		return
+	}
+
+	// one row per process, threads are not listed
+	ch <- prometheus.MustNewConstMetric(containerProcessCountDesc, prometheus.GaugeValue, float64(len(top.Processes)), cName)
+
+	if c.execCountMetrics {
+		ch <- prometheus.MustNewConstMetric(containerExecCountDesc, prometheus.GaugeValue, float64(max(len(top.Processes)-1, 0)), cName)
+	}
+}
+
+func (c *DockerCollector) attachMetrics(ch chan<- prometheus.Metric, config *container.Config, cName string) {
+	if config == nil {
+		return
+	}
+
+	streams := map[string]bool{
+		"stdin":  config.AttachStdin,
+		"stdout": config.AttachStdout,
+		"stderr": config.AttachStderr,
+	}
+
+	for stream, attached := range streams {
+		ch <- prometheus.MustNewConstMetric(containerAttachInfoDesc, prometheus.GaugeValue, 1, cName, stream, strconv.FormatBool(attached))
+	}
+}
+
+func (c *DockerCollector) blkioLimitMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, cName string) {
+	if hostConfig == nil {
+		return
+	}
+
+	limits := []struct {
+		desc    *prometheus.Desc
+		devices []*blkiodev.ThrottleDevice
+	}{
+		{containerDeviceReadBpsLimitDesc, hostConfig.BlkioDeviceReadBps},
+		{containerDeviceWriteBpsLimitDesc, hostConfig.BlkioDeviceWriteBps},
+		{containerDeviceReadIopsLimitDesc, hostConfig.BlkioDeviceReadIOps},
+		{containerDeviceWriteIopsLimitDesc, hostConfig.BlkioDeviceWriteIOps},
+	}
+
+	for _, limit := range limits {
+		for _, device := range limit.devices {
+			ch <- prometheus.MustNewConstMetric(limit.desc, prometheus.GaugeValue, float64(device.Rate), cName, throttleDeviceLabel(device))
+		}
+	}
+}
+
+// throttleDeviceLabel returns "major:minor" of the throttled device or its path if it can't be resolved,
+// e.g. if /dev of the host is not mounted into the dex container
+func throttleDeviceLabel(device *blkiodev.ThrottleDevice) string {
+	number, err := deviceNumber(device.Path)
+	if err != nil {
+		log.Debug("can't resolve device number: ", err)
+
+		return device.Path
+	}
+
+	return number
+}
+
+func (c *DockerCollector) stopMetrics(ch chan<- prometheus.Metric, config *container.Config, cName string) {
+	if config == nil {
+		return
+	}
+
+	// docker sends SIGTERM if no stop signal is configured
+	stopSignal := config.StopSignal
+	if stopSignal == "" {
+		stopSignal = "SIGTERM"
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerStopSignalInfoDesc, prometheus.GaugeValue, 1, cName, stopSignal)
+
+	// docker waits 10 seconds before killing the container if no timeout is configured
+	stopTimeout := 10
+	if config.StopTimeout != nil {
+		stopTimeout = *config.StopTimeout
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerStopTimeoutSecondsDesc, prometheus.GaugeValue, float64(stopTimeout), cName)
+}
+
+// threadMetrics sums up the threads of all processes in the container. The command is executed
+// with sh, so it is not available for containers without a shell
+func (c *DockerCollector) threadMetrics(ctx context.Context, ch chan<- prometheus.Metric, containerID string, cName string) {
+	out, err := c.execInContainer(ctx, containerID, "sh", "-c", "grep -h '^Threads:' /proc/[0-9]*/status 2>/dev/null; true")
+	if err != nil {
+		log.Debug("can't count container threads: ", err)
+		c.countExecTimeout(ctx, cName)
+
+		return
+	}
+
+	var threads int
+	for _, line := range strings.Split(out, "\n") {
+		if n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Threads:"))); err == nil {
+			threads += n
+		}
+	}
+
+	// the executing shell itself has one thread
+	if threads > 0 {
+		threads--
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerThreadCountDesc, prometheus.GaugeValue, float64(threads), cName)
+}
+
+// fdMetrics counts the open file descriptors of all processes in the container. The command is
+// executed with sh at most once per interval, in between the last count is emitted
+func (c *DockerCollector) fdMetrics(ctx context.Context, ch chan<- prometheus.Metric, containerID string, cName string) {
+	var sample fdSample
+	if prev, ok := c.fdSamples.Load(containerID); ok {
+		sample = prev.(fdSample)
+	}
+
+	if time.Since(sample.counted) >= c.fdMetricsInterval {
+		// failed attempts are stored as well, so the command is not executed more often than the interval
+		fds, err := c.countFds(ctx, containerID, cName)
+		sample = fdSample{counted: time.Now(), ok: err == nil, fds: fds}
+		c.fdSamples.Store(containerID, sample)
+
+		if err != nil {
+			log.Debug("can't count container file descriptors: ", err)
+		}
+	}
+
+	if !sample.ok {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerOpenFileDescriptorsDesc, prometheus.GaugeValue, float64(sample.fds), cName)
 }
 
-func newDockerCollector() *DockerCollector {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// countFds executes the command counting the open file descriptors of all processes in the container
+func (c *DockerCollector) countFds(ctx context.Context, containerID string, cName string) (int, error) {
+	out, err := c.execInContainer(ctx, containerID, "sh", "-c", "ls /proc/[0-9]*/fd 2>/dev/null | grep -c '^[0-9]'; true")
 	if err != nil {
-		log.Fatalf("can't create docker client: %v", err)
+		c.countExecTimeout(ctx, cName)
+		return 0, err
 	}
 
-	return &DockerCollector{
-		cli: cli,
+	fds, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("can't parse output %q: %w", out, err)
 	}
+
+	return fds, nil
 }
 
-func (c *DockerCollector) Describe(_ chan<- *prometheus.Desc) {
+// tcpMetrics counts the established TCP connections in the network namespace of the container
+// with ss or, if not available, with netstat
+func (c *DockerCollector) tcpMetrics(ctx context.Context, ch chan<- prometheus.Metric, containerID string, cName string) {
+	var established int
+
+	if out, err := c.execInContainer(ctx, containerID, "ss", "-t", "-n", "state", "established"); err == nil {
+		// ss prints a header line
+		established = max(len(strings.Split(strings.TrimSpace(out), "\n"))-1, 0)
+	} else if out, err := c.execInContainer(ctx, containerID, "netstat", "-t", "-n"); err == nil {
+		established = strings.Count(out, "ESTABLISHED")
+	} else {
+		log.Debug("can't count container TCP connections: ", err)
+		c.countExecTimeout(ctx, cName)
+
+		return
+	}
 
+	ch <- prometheus.MustNewConstMetric(containerTcpConnectionsEstablishedDesc, prometheus.GaugeValue, float64(established), cName)
 }
 
-func (c *DockerCollector) Collect(ch chan<- prometheus.Metric) {
-	containers, err := c.cli.ContainerList(context.Background(), container.ListOptions{
-		All: true,
-	})
-	if err != nil {
-		log.Error("can't list containers: ", err)
+// fsMetrics emits the size of the read-write layer and the total root filesystem size of the container.
+// Docker has to walk the filesystem to calculate them, so this is done at most once per interval
+func (c *DockerCollector) fsMetrics(ctx context.Context, ch chan<- prometheus.Metric, containerID string, cName string) {
+	var sample fsSample
+	if prev, ok := c.fsSamples.Load(containerID); ok {
+		sample = prev.(fsSample)
+	}
+
+	if time.Since(sample.calculated) >= c.fsMetricsInterval {
+		inspect, _, err := c.cli.ContainerInspectWithRaw(ctx, containerID, true)
+		if err != nil {
+			log.Error("can't calculate container filesystem size: ", err)
+			c.countScrapeError(cName, scrapeErrorType(ctx, "fs_size"))
+
+			return
+		}
+
+		if inspect.SizeRw == nil || inspect.SizeRootFs == nil {
+			return
+		}
+
+		sample = fsSample{calculated: time.Now(), sizeRw: *inspect.SizeRw, sizeRootFs: *inspect.SizeRootFs}
+		c.fsSamples.Store(containerID, sample)
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerFsRwBytesDesc, prometheus.GaugeValue, float64(sample.sizeRw), cName)
+
+	ch <- prometheus.MustNewConstMetric(containerFsTotalBytesDesc, prometheus.GaugeValue, float64(sample.sizeRootFs), cName)
+}
+
+// maximal length of the last_output label value
+const healthOutputMaxLen = 64
+
+// healthLogMetrics counts the failed health checks in the health check log of the container. Docker
+// only keeps the most recent results, so the results are counted once by their end time across scrapes.
+// Failures dropped from the log between two scrapes are not counted
+func (c *DockerCollector) healthLogMetrics(ch chan<- prometheus.Metric, state *types.ContainerState, containerID string, cName string) {
+	if state == nil || state.Health == nil {
 		return
 	}
 
-	var wg sync.WaitGroup
+	var sample healthSample
+	if prev, ok := c.healthFailures.Load(containerID); ok {
+		sample = prev.(healthSample)
+	}
 
-	for _, container := range containers {
-		wg.Add(1)
+	var lastFailure *types.HealthcheckResult
+
+	latest := sample.counted
+
+	for _, result := range state.Health.Log {
+		if result == nil || result.ExitCode == 0 {
+			continue
+		}
 
-		go c.processContainer(container, ch, &wg)
+		lastFailure = result
+
+		if result.End.After(sample.counted) {
+			sample.failures++
+
+			if result.End.After(latest) {
+				latest = result.End
+			}
+		}
+	}
+
+	sample.counted = latest
+	c.healthFailures.Store(containerID, sample)
+
+	ch <- prometheus.MustNewConstMetric(containerHealthCheckFailureTotalDesc, prometheus.CounterValue, float64(sample.failures), cName)
+
+	if lastFailure != nil {
+		output := []rune(strings.TrimSpace(lastFailure.Output))
+		if len(output) > healthOutputMaxLen {
+			output = output[:healthOutputMaxLen]
+		}
+
+		ch <- prometheus.MustNewConstMetric(containerHealthCheckLastFailureInfoDesc, prometheus.GaugeValue, 1, cName, string(output))
 	}
-	wg.Wait()
 }
 
-func (c *DockerCollector) processContainer(cont types.Container, ch chan<- prometheus.Metric, wg *sync.WaitGroup) {
-	defer wg.Done()
+// metric values of the health check states
+var healthStatusValues = map[string]float64{
+	types.Healthy:   1,
+	types.Unhealthy: 0,
+	types.Starting:  -1,
+}
 
-	cName := strings.TrimPrefix(strings.Join(cont.Names, ";"), "/")
+// healthStatusMetrics emits the health check status, containers without health check get the value of "none"
+func (c *DockerCollector) healthStatusMetrics(ch chan<- prometheus.Metric, state *types.ContainerState, cName string) {
+	status := types.NoHealthcheck
+	if state != nil && state.Health != nil {
+		status = state.Health.Status
+	}
 
-	var isRunning, isRestarting, isExited float64
+	value, ok := healthStatusValues[status]
+	if !ok {
+		value = -2
+	}
 
-	if cont.State == "running" {
-		isRunning = 1
+	ch <- prometheus.MustNewConstMetric(containerHealthStatusDesc, prometheus.GaugeValue, value, cName)
+}
+
+// cpuBurstMetrics emits the number of periods in which the container used CPU burst. The docker
+// API types don't have a BurstPeriods field yet, it is looked up by reflection so the metric is
+// emitted as soon as a newer client version provides it
+func (c *DockerCollector) cpuBurstMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
+	periods := burstPeriods(containerStats)
+	if periods == 0 {
+		return
 	}
 
-	if cont.State == "restarting" {
-		isRestarting = 1
+	ch <- prometheus.MustNewConstMetric(containerCPUBurstPeriodsTotalDesc, prometheus.CounterValue, float64(periods), cName)
+}
+
+// burstPeriods returns the BurstPeriods of the throttling data, 0 if the field doesn't exist
+func burstPeriods(containerStats *container.StatsResponse) uint64 {
+	return uintField(containerStats.CPUStats.ThrottlingData, "BurstPeriods")
+}
+
+// uintField returns the unsigned integer field of the struct, 0 if the field doesn't exist in this
+// version of the docker API types
+func uintField(v any, name string) uint64 {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Struct {
+		return 0
 	}
 
-	if cont.State == "exited" {
-		isExited = 1
+	field := value.FieldByName(name)
+	if !field.IsValid() || !field.CanUint() {
+		return 0
 	}
 
-	// container state metric for all containers
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_container_running",
-		"1 if docker container is running, 0 otherwise",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, isRunning, cName)
+	return field.Uint()
+}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_container_restarting",
-		"1 if docker container is restarting, 0 otherwise",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, isRestarting, cName)
+// storageDriverMetrics emits the storage driver of the container filesystem
+func (c *DockerCollector) storageDriverMetrics(ch chan<- prometheus.Metric, driver string, cName string) {
+	if driver == "" {
+		driver = "unknown"
+	}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_container_exited",
-		"1 if docker container exited, 0 otherwise",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, isExited, cName)
+	ch <- prometheus.MustNewConstMetric(containerStorageDriverInfoDesc, prometheus.GaugeValue, 1, cName, driver)
+}
 
-	if inspect, err := c.cli.ContainerInspect(context.Background(), cont.ID); err != nil {
-		log.Fatal(err)
-	} else {
-		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-			"dex_container_restarts_total",
-			"Number of times the container has restarted",
-			labelCname,
-			nil,
-		), prometheus.CounterValue, float64(inspect.RestartCount), cName)
+// imageMetrics emits when the image of the container was last pulled or tagged on this host and when it was built
+func (c *DockerCollector) imageMetrics(ctx context.Context, ch chan<- prometheus.Metric, imageID string, scrape *scrapeInfo, cName string) {
+	value, _ := scrape.images.LoadOrStore(imageID, &imageInspect{})
+	inspect := value.(*imageInspect)
+
+	inspect.once.Do(func() {
+		inspect.image, _, inspect.err = c.cli.ImageInspectWithRaw(ctx, imageID)
+	})
+
+	image, err := inspect.image, inspect.err
+	if err != nil {
+		log.Error("can't inspect image: ", err)
+		c.countScrapeError(cName, scrapeErrorType(ctx, "image_inspect"))
+
+		return
 	}
 
-	// stats metrics only for running containers
-	if isRunning == 1 {
+	if !image.Metadata.LastTagTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(containerImageLastPullTimestampSecondsDesc, prometheus.GaugeValue, float64(image.Metadata.LastTagTime.Unix()), cName, imageID)
 
-		if stats, err := c.cli.ContainerStats(context.Background(), cont.ID, false); err != nil {
-			log.Fatal(err)
-		} else {
-			var containerStats container.StatsResponse
-			err := json.NewDecoder(stats.Body).Decode(&containerStats)
-			if err != nil {
-				log.Error("can't read api stats: ", err)
-			}
-			if err := stats.Body.Close(); err != nil {
-				log.Error("can't close body: ", err)
+		ch <- prometheus.MustNewConstMetric(containerImageFreshnessDaysDesc, prometheus.GaugeValue, time.Since(image.Metadata.LastTagTime).Hours()/24, cName)
+	}
+
+	// build time of the image, an old image may have been pulled recently
+	if created, err := time.Parse(time.RFC3339Nano, image.Created); err == nil {
+		ch <- prometheus.MustNewConstMetric(containerImageCreationAgeDaysDesc, prometheus.GaugeValue, time.Since(created).Hours()/24, cName)
+
+		ch <- prometheus.MustNewConstMetric(containerImageCreationDateInfoDesc, prometheus.GaugeValue, 1, cName, created.UTC().Format(time.DateOnly))
+	}
+}
+
+// upper bounds of the per core utilization histogram buckets in percent
+var perCoreUtilizationBuckets = []float64{0, 10, 25, 50, 75, 90, 100}
+
+// cpuHistogramMetrics emits the distribution of the CPU utilization over the cores between the last
+// two stats snapshots. Per core usage is only reported with cgroups v1
+func (c *DockerCollector) cpuHistogramMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
+	perCPU := containerStats.CPUStats.CPUUsage.PercpuUsage
+	prePerCPU := containerStats.PreCPUStats.CPUUsage.PercpuUsage
+	systemDelta := containerStats.CPUStats.SystemUsage - containerStats.PreCPUStats.SystemUsage
+	cpus := onlineCPUs(containerStats)
+
+	if len(perCPU) == 0 || len(perCPU) != len(prePerCPU) || systemDelta == 0 || cpus == 0 {
+		return
+	}
+
+	// system usage is the sum over all host CPUs
+	coreDelta := float64(systemDelta) / float64(cpus)
+
+	buckets := make(map[float64]uint64, len(perCoreUtilizationBuckets))
+
+	var sum float64
+
+	for i := range perCPU {
+		utilization := float64(perCPU[i]-prePerCPU[i]) / coreDelta * 100.0
+		sum += utilization
+
+		for _, bound := range perCoreUtilizationBuckets {
+			if utilization <= bound {
+				buckets[bound]++
 			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstHistogram(containerCPUPerCoreUtilizationPercentDesc, uint64(len(perCPU)), sum, buckets, cName)
+}
+
+// root of the cgroup v2 hierarchy of the host
+const cgroupRoot = "/sys/fs/cgroup"
 
-			c.blockIoMetrics(ch, &containerStats, cName)
+// memory.events counters of the cgroup v2 memory controller which are emitted as pressure levels
+var memoryEventLevels = []string{"low", "high", "max"}
 
-			c.memoryMetrics(ch, &containerStats, cName)
+// enableMemoryPressureEvents subscribes to the docker OOM events and registers the collection of the
+// cgroup memory events. Memory events are only available with cgroups v2
+func (c *DockerCollector) enableMemoryPressureEvents(ctx context.Context) {
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		log.Error("can't get docker info, memory pressure events disabled: ", err)
+		return
+	}
+
+	if info.CgroupVersion != "2" {
+		log.Warn("memory pressure events require cgroups v2, disabled")
+		return
+	}
 
-			c.networkMetrics(ch, &containerStats, cName)
+	c.memoryPressureEvents = true
 
-			c.CPUMetrics(ch, &containerStats, cName)
+	go c.watchEvents(ctx, events.ContainerEventType, c.handleMemoryEvent, events.ActionOOM)
+
+	c.AddCollector(MetricCollectorFunc(func(ch chan<- prometheus.Metric, d *ContainerData) {
+		c.memoryEventsMetrics(ch, d.ID, d.Inspect.HostConfig, d.Scrape.CgroupDriver, d.Name)
+	}))
+}
 
-			c.pidsMetrics(ch, &containerStats, cName)
+// handleMemoryEvent counts the OOM events per container, the counts of removed containers are dropped
+// with the other container state
+func (c *DockerCollector) handleMemoryEvent(msg events.Message) {
+	for {
+		count, loaded := c.oomEvents.LoadOrStore(msg.Actor.ID, uint64(1))
+		if !loaded || c.oomEvents.CompareAndSwap(msg.Actor.ID, count, count.(uint64)+1) {
+			return
 		}
 	}
 }
 
-func (c *DockerCollector) CPUMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	totalUsage := containerStats.CPUStats.CPUUsage.TotalUsage
-	cpuDelta := totalUsage - containerStats.PreCPUStats.CPUUsage.TotalUsage
-	sysemDelta := containerStats.CPUStats.SystemUsage - containerStats.PreCPUStats.SystemUsage
+// oomEventsMetrics emits the OOM events of the container, also after it exited
+func (c *DockerCollector) oomEventsMetrics(ch chan<- prometheus.Metric, containerID string, cName string) {
+	if !c.memoryPressureEvents {
+		return
+	}
 
-	cpuUtilization := float64(cpuDelta) / float64(sysemDelta) * 100.0
+	var count uint64
+	if value, ok := c.oomEvents.Load(containerID); ok {
+		count = value.(uint64)
+	}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_cpu_utilization_percent",
-		"CPU utilization in percent",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, cpuUtilization, cName)
+	ch <- prometheus.MustNewConstMetric(containerMemoryPressureEventsTotalDesc, prometheus.CounterValue, float64(count), cName, "oom")
+}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_cpu_utilization_seconds_total",
-		"Cumulative CPU utilization in seconds",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(totalUsage)/1e9, cName)
+// handleImageEvent counts the pulls and pushes of an image
+func (c *DockerCollector) handleImageEvent(msg events.Message) {
+	imageName := msg.Actor.Attributes["name"]
+	if imageName == "" {
+		imageName, _ = parseImageRef(msg.Actor.ID)
+	}
+
+	switch msg.Action {
+	case events.ActionPull:
+		c.imagePulls.WithLabelValues(imageName).Inc()
+		c.lastImagePull.WithLabelValues(imageName).Set(float64(msg.Time))
+	case events.ActionPush:
+		c.imagePushes.WithLabelValues(imageName).Inc()
+	}
 }
 
-func (c *DockerCollector) networkMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_network_rx_bytes_total",
-		"Network received bytes total",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(containerStats.Networks["eth0"].RxBytes), cName)
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_network_tx_bytes_total",
-		"Network sent bytes total",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(containerStats.Networks["eth0"].TxBytes), cName)
+// memoryEventsMetrics emits the memory events of the container cgroup. The cgroup hierarchy of the
+// host must be available at /sys/fs/cgroup, otherwise only OOM events are counted
+func (c *DockerCollector) memoryEventsMetrics(ch chan<- prometheus.Metric, containerID string, hostConfig *container.HostConfig,
+	cgroupDriver string, cName string) {
+	var cgroupPath string
+
+	switch {
+	case cgroupDriver == "systemd":
+		cgroupPath = filepath.Join(cgroupRoot, "system.slice", "docker-"+containerID+".scope")
+	case hostConfig != nil && hostConfig.CgroupParent != "":
+		cgroupPath = filepath.Join(cgroupRoot, hostConfig.CgroupParent, containerID)
+	default:
+		cgroupPath = filepath.Join(cgroupRoot, "docker", containerID)
+	}
+
+	content, err := os.ReadFile(filepath.Join(cgroupPath, "memory.events"))
+	if err != nil {
+		log.Debug("can't read cgroup memory events: ", err)
+		return
+	}
+
+	counts := parseMemoryEvents(string(content))
+
+	for _, level := range memoryEventLevels {
+		if count, ok := counts[level]; ok {
+			ch <- prometheus.MustNewConstMetric(containerMemoryPressureEventsTotalDesc, prometheus.CounterValue, count, cName, level)
+		}
+	}
 }
 
-func (c *DockerCollector) memoryMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	// From official documentation
-	//Note: On Linux, the Docker CLI reports memory usage by subtracting page cache usage from the total memory usage.
-	//The API does not perform such a calculation but rather provides the total memory usage and the amount from the page cache so that clients can use the data as needed.
-	memoryUsage := containerStats.MemoryStats.Usage - containerStats.MemoryStats.Stats["cache"]
-	memoryTotal := containerStats.MemoryStats.Limit
+// parseMemoryEvents returns the counters of a memory.events file by key, lines which aren't a key
+// and a number are skipped
+func parseMemoryEvents(content string) map[string]float64 {
+	counts := make(map[string]float64)
 
-	memoryUtilization := float64(memoryUsage) / float64(memoryTotal) * 100.0
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_memory_usage_bytes",
-		"Total memory usage bytes",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(memoryUsage), cName)
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_memory_total_bytes",
-		"Total memory bytes",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, float64(memoryTotal), cName)
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_memory_utilization_percent",
-		"Memory utilization percent",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, memoryUtilization, cName)
+	for _, line := range strings.Split(content, "\n") {
+		if key, value, found := strings.Cut(line, " "); found {
+			if count, err := strconv.ParseFloat(value, 64); err == nil {
+				counts[key] = count
+			}
+		}
+	}
+
+	return counts
 }
 
-func (c *DockerCollector) blockIoMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	var readTotal, writeTotal uint64
-	for _, b := range containerStats.BlkioStats.IoServiceBytesRecursive {
-		if strings.EqualFold(b.Op, "read") {
-			readTotal += b.Value
+// observeRunDuration records the run duration of an exited container once per run
+func (c *DockerCollector) observeRunDuration(cont types.Container, state *types.ContainerState) {
+	if state == nil || state.Running {
+		return
+	}
+
+	startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil || startedAt.IsZero() {
+		return
+	}
+
+	finishedAt, err := time.Parse(time.RFC3339Nano, state.FinishedAt)
+	if err != nil || finishedAt.Before(startedAt) {
+		return
+	}
+
+	if prev, loaded := c.observedRuns.Swap(cont.ID, state.FinishedAt); loaded && prev == state.FinishedAt {
+		return
+	}
+
+	imageName, _ := parseImageRef(cont.Image)
+
+	c.runDuration.WithLabelValues(imageName, strconv.Itoa(state.ExitCode)).Observe(finishedAt.Sub(startedAt).Seconds())
+}
+
+// cgroupParentMetrics emits the parent cgroup of the container if it is not the default one
+func (c *DockerCollector) cgroupParentMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, cName string) {
+	if hostConfig == nil || hostConfig.CgroupParent == "" {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerCgroupParentInfoDesc, prometheus.GaugeValue, 1, cName, hostConfig.CgroupParent)
+}
+
+// securityMetrics emits the security related settings of the container
+func (c *DockerCollector) securityMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, daemonSecurityOptions []string, cName string) {
+	if hostConfig == nil {
+		return
+	}
+
+	var remapped float64
+
+	switch hostConfig.UsernsMode {
+	case "host":
+		remapped = 0
+	case "":
+		// daemon default, remapped if the daemon runs with --userns-remap
+		for _, option := range daemonSecurityOptions {
+			if option == "name=userns" {
+				remapped = 1
+			}
 		}
-		if strings.EqualFold(b.Op, "write") {
-			writeTotal += b.Value
+	default:
+		remapped = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerUsernsRemappedDesc, prometheus.GaugeValue, remapped, cName)
+}
+
+// label set by kubernetes on containers of pods with a RuntimeClass
+const labelK8sRuntimeClass = "io.kubernetes.pod.runtimeclass"
+
+// kubernetesMetrics emits the kubernetes metadata of containers created by kubernetes
+func (c *DockerCollector) kubernetesMetrics(ch chan<- prometheus.Metric, labels map[string]string, cName string) {
+	if runtimeClass, ok := labels[labelK8sRuntimeClass]; ok && runtimeClass != "" {
+		ch <- prometheus.MustNewConstMetric(containerRuntimeClassInfoDesc, prometheus.GaugeValue, 1, cName, runtimeClass)
+	}
+}
+
+// imageTagMetrics emits the image reference of the container split into name and tag
+func (c *DockerCollector) imageTagMetrics(ch chan<- prometheus.Metric, imageRef string, cName string) {
+	name, tag := parseImageRef(imageRef)
+
+	ch <- prometheus.MustNewConstMetric(containerImageTagInfoDesc, prometheus.GaugeValue, 1, cName, name, tag)
+}
+
+// parseImageRef splits an image reference into name and tag. The tag defaults to "latest", for
+// references by digest the digest is returned as tag. Image IDs (sha256:...) have no tag
+func parseImageRef(ref string) (name string, tag string) {
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref, ""
+	}
+
+	name, digest, hasDigest := strings.Cut(ref, "@")
+
+	// a colon after the last slash separates the tag, others belong to a registry port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+
+	if hasDigest {
+		return name, digest
+	}
+
+	return name, "latest"
+}
+
+// crash reasons by exit code of the die event
+var crashReasons = map[int]string{
+	137: "sigkill",
+	139: "segfault",
+}
+
+// handleDieEvent records the exit code of a stopped container and counts crashes
+func (c *DockerCollector) handleDieEvent(msg events.Message) {
+	exitCode, err := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+	if err != nil {
+		log.Debug("can't parse exit code of die event: ", err)
+		return
+	}
+
+	sample := dieSample{exitCode: exitCode, crashes: make(map[string]uint64)}
+
+	if prev, ok := c.dieEvents.Load(msg.Actor.ID); ok {
+		for reason, count := range prev.(dieSample).crashes {
+			sample.crashes[reason] = count
 		}
 	}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_block_io_read_bytes_total",
-		"Block I/O read bytes",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(readTotal), cName)
+	if reason, ok := crashReasons[exitCode]; ok {
+		sample.crashes[reason]++
+	}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_block_io_write_bytes_total",
-		"Block I/O write bytes",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(writeTotal), cName)
+	c.dieEvents.Store(msg.Actor.ID, sample)
 }
 
-func (c *DockerCollector) pidsMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_pids_current",
-		"Current number of pids in the cgroup",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(containerStats.PidsStats.Current), cName)
+// dieMetrics emits the exit code of the last die event of the container and the crashes since dex was started
+func (c *DockerCollector) dieMetrics(ch chan<- prometheus.Metric, containerID string, cName string) {
+	value, ok := c.dieEvents.Load(containerID)
+	if !ok {
+		return
+	}
+
+	sample := value.(dieSample)
+
+	ch <- prometheus.MustNewConstMetric(containerLastDieExitCodeDesc, prometheus.GaugeValue, float64(sample.exitCode), cName)
+
+	for reason, count := range sample.crashes {
+		ch <- prometheus.MustNewConstMetric(containerCrashTotalDesc, prometheus.CounterValue, float64(count), cName, reason)
+	}
+}
+
+// startMetrics emits when the container was started last, 0 if it was never started
+func (c *DockerCollector) startMetrics(ch chan<- prometheus.Metric, state *types.ContainerState, cName string) {
+	if state == nil {
+		return
+	}
+
+	var started float64
+
+	// never started containers have the zero time "0001-01-01T00:00:00Z"
+	if startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt); err == nil && !startedAt.IsZero() {
+		started = float64(startedAt.Unix())
+	}
+
+	ch <- prometheus.MustNewConstMetric(containerStartTimestampSecondsDesc, prometheus.GaugeValue, started, cName)
 }
@@ -0,0 +1,2711 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// version prefix of the docker API paths, e.g. /v1.45
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// fakeDaemon serves the docker API endpoints used by the collector
+type fakeDaemon struct {
+	mu sync.Mutex
+
+	containers []types.Container
+
+	// container ID -> inspect result
+	inspects map[string]types.ContainerJSON
+
+	// container ID -> stats, the stats call fails for containers without stats
+	stats map[string]container.StatsResponse
+
+	// delay of each stats call
+	statsDelay time.Duration
+
+	// delay of each container inspect call
+	inspectDelay time.Duration
+
+	// concurrent container inspect calls and their maximum
+	inspecting    int
+	maxInspecting int
+
+	info system.Info
+
+	// the ping fails like with an unreachable daemon
+	pingFails bool
+
+	// events sent to each subscriber whose filters match them, before the stream blocks
+	events []events.Message
+
+	// container ID -> processes listed by top, the call fails for containers with nil processes.
+	// Containers without an entry run a single process
+	top map[string][][]string
+
+	// image ID -> inspect result, images without one are created on 2024-01-01
+	images map[string]types.ImageInspect
+
+	// runs the command of an exec in the container and returns its output and exit code, exec
+	// creation fails if nil
+	exec func(containerID string, cmd []string) (string, int)
+
+	// exec ID -> created exec
+	execs map[string]*fakeExec
+
+	// API path without version -> number of requests
+	requests map[string]int
+}
+
+// newFakeDaemon starts a fake docker daemon and points DOCKER_HOST to it for the test
+func newFakeDaemon(t *testing.T) *fakeDaemon {
+	t.Helper()
+
+	d := &fakeDaemon{
+		inspects: make(map[string]types.ContainerJSON),
+		stats:    make(map[string]container.StatsResponse),
+		info:     system.Info{NCPU: 4, MemTotal: 16 << 30},
+		top:      make(map[string][][]string),
+		images:   make(map[string]types.ImageInspect),
+		execs:    make(map[string]*fakeExec),
+		requests: make(map[string]int),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(d.serveHTTP))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("DOCKER_HOST", "tcp://"+srv.Listener.Addr().String())
+
+	return d
+}
+
+// addContainer adds a container with the state and an inspect result matching it. Running
+// containers get stats
+func (d *fakeDaemon) addContainer(id, name, image, state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.containers = append(d.containers, types.Container{
+		ID:      id,
+		Names:   []string{"/" + name},
+		Image:   image,
+		State:   state,
+		Created: int64(len(d.containers)),
+		Labels:  map[string]string{},
+	})
+
+	d.inspects[id] = types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + name,
+			Image:      "sha256:" + id,
+			State:      &types.ContainerState{Status: state, Running: state == "running"},
+			HostConfig: &container.HostConfig{},
+		},
+		Config: &container.Config{Labels: map[string]string{}},
+	}
+
+	if state == "running" {
+		var stats container.StatsResponse
+		stats.Read = time.Now()
+		stats.CPUStats.OnlineCPUs = 4
+		stats.MemoryStats.Limit = 1 << 30
+
+		d.stats[id] = stats
+	}
+}
+
+// fakeExec is an exec created in a container of the fake daemon
+type fakeExec struct {
+	containerID string
+	cmd         []string
+	exitCode    int
+}
+
+// update changes the inspect result or the stats of a container
+func (d *fakeDaemon) update(f func(d *fakeDaemon)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f(d)
+}
+
+// requestCount returns the number of requests of the API path without version
+func (d *fakeDaemon) requestCount(path string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.requests[path]
+}
+
+func (d *fakeDaemon) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+
+	d.mu.Lock()
+	d.requests[path]++
+	delay, inspectDelay := d.statsDelay, d.inspectDelay
+	d.mu.Unlock()
+
+	w.Header().Set("Api-Version", "1.45")
+
+	switch {
+	case path == "/_ping":
+		d.mu.Lock()
+		pingFails := d.pingFails
+		d.mu.Unlock()
+
+		if pingFails {
+			http.Error(w, `{"message": "daemon unavailable"}`, http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte("OK"))
+	case path == "/events":
+		d.streamEvents(w, r)
+	case path == "/info":
+		d.writeJSON(w, func() any { return d.info })
+	case path == "/containers/json":
+		d.writeJSON(w, func() any { return d.containers })
+	case strings.HasPrefix(path, "/images/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+		d.writeJSON(w, func() any {
+			if image, ok := d.images[id]; ok {
+				return image
+			}
+
+			return types.ImageInspect{ID: id, Created: "2024-01-01T00:00:00Z"}
+		})
+	case strings.HasSuffix(path, "/stats"):
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/stats")
+
+		d.mu.Lock()
+		stats, ok := d.stats[id]
+		d.mu.Unlock()
+
+		if !ok {
+			http.Error(w, `{"message": "no stats"}`, http.StatusInternalServerError)
+			return
+		}
+
+		d.writeJSON(w, func() any { return stats })
+	case strings.HasSuffix(path, "/exec"):
+		d.createExec(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/exec"))
+	case strings.HasPrefix(path, "/exec/") && strings.HasSuffix(path, "/start"):
+		d.startExec(w, strings.TrimSuffix(strings.TrimPrefix(path, "/exec/"), "/start"))
+	case strings.HasPrefix(path, "/exec/") && strings.HasSuffix(path, "/json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/exec/"), "/json")
+		d.writeJSON(w, func() any { return container.ExecInspect{ExecID: id, ExitCode: d.execs[id].exitCode} })
+	case strings.HasSuffix(path, "/top"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/top")
+
+		d.mu.Lock()
+		processes, ok := d.top[id]
+		d.mu.Unlock()
+
+		if !ok {
+			processes = [][]string{{"1", "nginx"}}
+		} else if processes == nil {
+			http.Error(w, `{"message": "top failed"}`, http.StatusInternalServerError)
+			return
+		}
+
+		d.writeJSON(w, func() any { return container.ContainerTopOKBody{Titles: []string{"PID", "CMD"}, Processes: processes} })
+	case strings.HasSuffix(path, "/json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+
+		d.mu.Lock()
+		inspect, ok := d.inspects[id]
+		d.inspecting++
+		d.maxInspecting = max(d.maxInspecting, d.inspecting)
+		d.mu.Unlock()
+
+		time.Sleep(inspectDelay)
+
+		d.mu.Lock()
+		d.inspecting--
+		d.mu.Unlock()
+
+		if !ok {
+			http.Error(w, `{"message": "no such container"}`, http.StatusNotFound)
+			return
+		}
+
+		d.writeJSON(w, func() any { return inspect })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamEvents writes the events matching the type and action filters of the request, the stream
+// is open until the collector stops watching
+func (d *fakeDaemon) streamEvents(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		http.Error(w, `{"message": "invalid filters"}`, http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	messages := d.events
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+
+	for _, msg := range messages {
+		if args.ExactMatch("type", string(msg.Type)) && args.ExactMatch("event", string(msg.Action)) {
+			_ = encoder.Encode(msg)
+		}
+	}
+
+	w.(http.Flusher).Flush()
+	<-r.Context().Done()
+}
+
+// createExec creates an exec of the command in the request for the container
+func (d *fakeDaemon) createExec(w http.ResponseWriter, r *http.Request, containerID string) {
+	var options container.ExecOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		http.Error(w, `{"message": "invalid exec options"}`, http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.exec == nil {
+		http.Error(w, `{"message": "exec not supported"}`, http.StatusInternalServerError)
+		return
+	}
+
+	id := fmt.Sprintf("exec-%d", len(d.execs))
+	d.execs[id] = &fakeExec{containerID: containerID, cmd: options.Cmd}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(types.IDResponse{ID: id})
+}
+
+// startExec runs the exec and writes its output as multiplexed stream to the hijacked connection
+func (d *fakeDaemon) startExec(w http.ResponseWriter, id string) {
+	d.mu.Lock()
+	exec, ok := d.execs[id]
+	run := d.exec
+	d.mu.Unlock()
+
+	if !ok {
+		http.Error(w, `{"message": "no such exec"}`, http.StatusNotFound)
+		return
+	}
+
+	out, exitCode := run(exec.containerID, exec.cmd)
+
+	d.mu.Lock()
+	exec.exitCode = exitCode
+	d.mu.Unlock()
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n"))
+	_, _ = stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write([]byte(out))
+}
+
+// writeJSON encodes the value returned by f while holding the lock
+func (d *fakeDaemon) writeJSON(w http.ResponseWriter, f func() any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(f())
+}
+
+// newTestCollector creates a collector for the fake daemon with the configuration of the environment
+func newTestCollector(t *testing.T) *DockerCollector {
+	t.Helper()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("can't load config: %v", err)
+	}
+
+	// canceled before the fake daemon is closed, so the event streams end
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return newDockerCollector(ctx, cfg)
+}
+
+// gather collects the metrics with a pedantic registry, which checks their consistency
+func gather(t *testing.T, collector prometheus.Collector) []*dto.MetricFamily {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(collector); err != nil {
+		t.Fatalf("can't register collector: %v", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+
+	return families
+}
+
+// findMetric returns the metric of the family with all the labels, nil if there is none
+func findMetric(families []*dto.MetricFamily, name string, labels map[string]string) *dto.Metric {
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	metrics:
+		for _, m := range family.GetMetric() {
+			for labelName, value := range labels {
+				if !hasLabelValue(m.GetLabel(), labelName, value) {
+					continue metrics
+				}
+			}
+
+			return m
+		}
+	}
+
+	return nil
+}
+
+func hasLabelValue(labels []*dto.LabelPair, name, value string) bool {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return label.GetValue() == value
+		}
+	}
+
+	return false
+}
+
+// metricValue returns the value of a gauge, counter or untyped metric, it fails if the metric is missing
+func metricValue(t *testing.T, families []*dto.MetricFamily, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	m := findMetric(families, name, labels)
+	if m == nil {
+		t.Fatalf("metric %s %v is missing", name, labels)
+	}
+
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	default:
+		return m.Untyped.GetValue()
+	}
+}
+
+// waitForMetric gathers the metrics of the collector until the metric has the value, e.g. after the
+// collector handled docker events, and fails the test if it doesn't within a few seconds
+func waitForMetric(t *testing.T, collector prometheus.Collector, name string, labels map[string]string, want float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		m := findMetric(gather(t, collector), name, labels)
+		if m != nil {
+			if value, _ := sampleValue(m); value == want {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("metric %s %v = %v, want %v", name, labels, m, want)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// collectorFunc adapts a function emitting metrics to an unchecked collector
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(_ chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	f(ch)
+}
+
+func TestDockerCollector_Collect_ErrorPropagation(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "ok", "nginx:1.25", "running")
+	d.addContainer("bbbb", "broken", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) { delete(d.stats, "bbbb") })
+
+	families := gather(t, newTestCollector(t))
+
+	if got := metricValue(t, families, "dex_scrape_errors_total", map[string]string{"container_name": "broken", "error_type": "stats"}); got != 1 {
+		t.Errorf("dex_scrape_errors_total of the failing container = %v, want 1", got)
+	}
+
+	if m := findMetric(families, "dex_scrape_errors_total", map[string]string{"container_name": "ok"}); m != nil {
+		t.Errorf("unexpected scrape error of the successful container: %v", m)
+	}
+
+	if findMetric(families, "dex_cpu_utilization_percent", map[string]string{"container_name": "ok"}) == nil {
+		t.Error("stats metrics of the successful container are missing")
+	}
+
+	if m := findMetric(families, "dex_cpu_utilization_percent", map[string]string{"container_name": "broken"}); m != nil {
+		t.Errorf("unexpected stats metrics of the failing container: %v", m)
+	}
+
+	// the state metrics don't depend on the stats
+	if got := metricValue(t, families, "dex_container_running", map[string]string{"container_name": "broken"}); got != 1 {
+		t.Errorf("dex_container_running of the failing container = %v, want 1", got)
+	}
+}
+
+func TestProcessContainer_StatsErrorIsNotFatal(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "removed", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) { delete(d.stats, "aaaa") })
+
+	c := newTestCollector(t)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	for i := 0; i < 2; i++ {
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("can't gather metrics: %v", err)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+
+	if got := metricValue(t, families, "dex_scrape_errors_total", map[string]string{"container_name": "removed", "error_type": "stats"}); got != 3 {
+		t.Errorf("dex_scrape_errors_total = %v, want 3", got)
+	}
+
+	// the container is not stale before the stale threshold
+	if got := metricValue(t, families, "dex_container_stale", map[string]string{"container_name": "removed"}); got != 0 {
+		t.Errorf("dex_container_stale = %v, want 0", got)
+	}
+}
+
+func TestCPUMetrics_ThrottledTime(t *testing.T) {
+	var stats container.StatsResponse
+	stats.CPUStats.ThrottlingData = container.ThrottlingData{Periods: 100, ThrottledPeriods: 10, ThrottledTime: 1_500_000_000}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.CPUMetrics(ch, &stats, &container.HostConfig{}, 4, "web")
+	}))
+
+	for name, want := range map[string]float64{
+		"dex_cpu_throttle_periods_total":  100,
+		"dex_cpu_throttled_periods_total": 10,
+		"dex_cpu_throttled_seconds_total": 1.5,
+	} {
+		if got := metricValue(t, families, name, map[string]string{"container_name": "web"}); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCPUMetrics_QuotaRatio(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostConfig container.HostConfig
+		wantRatio  float64
+		wantPeriod float64
+	}{
+		{name: "unlimited", hostConfig: container.HostConfig{}, wantRatio: 0, wantPeriod: 0.1},
+		{name: "quota", hostConfig: container.HostConfig{Resources: container.Resources{CPUQuota: 150000, CPUPeriod: 100000}}, wantRatio: 1.5, wantPeriod: 0.1},
+		{name: "default period", hostConfig: container.HostConfig{Resources: container.Resources{CPUQuota: 50000}}, wantRatio: 0.5, wantPeriod: 0.1},
+		{name: "custom period", hostConfig: container.HostConfig{Resources: container.Resources{CPUQuota: 100000, CPUPeriod: 50000}}, wantRatio: 2, wantPeriod: 0.05},
+		{name: "nano cpus", hostConfig: container.HostConfig{Resources: container.Resources{NanoCPUs: 1_500_000_000}}, wantRatio: 1.5, wantPeriod: 0.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.CPUMetrics(ch, &stats, &tt.hostConfig, 4, "web")
+			}))
+
+			labels := map[string]string{"container_name": "web"}
+			if got := metricValue(t, families, "dex_container_cpu_quota_ratio", labels); got != tt.wantRatio {
+				t.Errorf("dex_container_cpu_quota_ratio = %v, want %v", got, tt.wantRatio)
+			}
+			if got := metricValue(t, families, "dex_container_cpu_period_seconds", labels); got != tt.wantPeriod {
+				t.Errorf("dex_container_cpu_period_seconds = %v, want %v", got, tt.wantPeriod)
+			}
+		})
+	}
+}
+
+func TestBlockIoMetrics(t *testing.T) {
+	var stats container.StatsResponse
+	stats.BlkioStats.IoServiceBytesRecursive = []container.BlkioStatEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 100},
+		{Major: 8, Minor: 0, Op: "Write", Value: 200},
+		{Major: 8, Minor: 16, Op: "read", Value: 50},
+		{Major: 8, Minor: 0, Op: "Discard", Value: 4096},
+		{Major: 8, Minor: 16, Op: "discard", Value: 1024},
+		{Major: 8, Minor: 0, Op: "Total", Value: 300},
+	}
+	stats.BlkioStats.IoServicedRecursive = []container.BlkioStatEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 10},
+		{Major: 8, Minor: 0, Op: "Write", Value: 20},
+		{Major: 8, Minor: 16, Op: "Write", Value: 5},
+		{Major: 8, Minor: 0, Op: "Discard", Value: 3},
+		{Major: 8, Minor: 0, Op: "Total", Value: 30},
+	}
+
+	c := &DockerCollector{blockIoPerDevice: true}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.blockIoMetrics(ch, &stats, "web")
+	}))
+
+	for _, tc := range []struct {
+		name   string
+		device string
+		want   float64
+	}{
+		{"dex_block_io_read_bytes_total", "total", 150},
+		{"dex_block_io_read_bytes_total", "8:0", 100},
+		{"dex_block_io_read_bytes_total", "8:16", 50},
+		{"dex_block_io_write_bytes_total", "total", 200},
+		{"dex_block_io_read_ops_total", "", 10},
+		{"dex_block_io_write_ops_total", "", 25},
+		{"dex_container_block_io_discard_bytes_total", "", 5120},
+		{"dex_container_block_io_discard_ops_total", "", 3},
+	} {
+		labels := map[string]string{"container_name": "web"}
+		if tc.device != "" {
+			labels["device"] = tc.device
+		}
+
+		if got := metricValue(t, families, tc.name, labels); got != tc.want {
+			t.Errorf("%s %v = %v, want %v", tc.name, labels, got, tc.want)
+		}
+	}
+}
+
+func TestBlockIoWaitMetrics_Latency(t *testing.T) {
+	tests := []struct {
+		name      string
+		wait      []container.BlkioStatEntry
+		ops       []container.BlkioStatEntry
+		wantRead  float64
+		wantWrite float64
+	}{
+		{
+			name: "latency",
+			wait: []container.BlkioStatEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 30_000_000},
+				{Major: 8, Minor: 16, Op: "read", Value: 10_000_000},
+				{Major: 8, Minor: 0, Op: "Write", Value: 50_000_000},
+				{Major: 8, Minor: 0, Op: "Total", Value: 90_000_000},
+			},
+			ops: []container.BlkioStatEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 10},
+				{Major: 8, Minor: 16, Op: "Read", Value: 10},
+				{Major: 8, Minor: 0, Op: "Write", Value: 5},
+			},
+			wantRead:  2,
+			wantWrite: 10,
+		},
+		{
+			name:      "zero ops",
+			wait:      []container.BlkioStatEntry{{Major: 8, Minor: 0, Op: "Read", Value: 30_000_000}},
+			wantRead:  0,
+			wantWrite: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.BlkioStats.IoWaitTimeRecursive = tt.wait
+			stats.BlkioStats.IoServicedRecursive = tt.ops
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.blockIoWaitMetrics(ch, &stats, "web")
+			}))
+
+			labels := map[string]string{"container_name": "web"}
+			if got := metricValue(t, families, "dex_container_block_io_read_avg_latency_ms", labels); got != tt.wantRead {
+				t.Errorf("dex_container_block_io_read_avg_latency_ms = %v, want %v", got, tt.wantRead)
+			}
+			if got := metricValue(t, families, "dex_container_block_io_write_avg_latency_ms", labels); got != tt.wantWrite {
+				t.Errorf("dex_container_block_io_write_avg_latency_ms = %v, want %v", got, tt.wantWrite)
+			}
+		})
+	}
+}
+
+func TestBlockIoWaitMetrics_WaitTime(t *testing.T) {
+	tests := []struct {
+		name string
+		wait []container.BlkioStatEntry
+		want float64
+	}{
+		{
+			name: "nanoseconds to seconds",
+			wait: []container.BlkioStatEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 1_500_000_000},
+				{Major: 8, Minor: 0, Op: "Write", Value: 500_000_000},
+				{Major: 8, Minor: 0, Op: "Sync", Value: 2_000_000_000},
+				{Major: 8, Minor: 0, Op: "Total", Value: 2_000_000_000},
+			},
+			want: 2,
+		},
+		{name: "empty", wait: nil, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.BlkioStats.IoWaitTimeRecursive = tt.wait
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.blockIoWaitMetrics(ch, &stats, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_block_io_wait_time_seconds_total", map[string]string{"container_name": "web"}); got != tt.want {
+				t.Errorf("dex_container_block_io_wait_time_seconds_total = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostMetrics(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "db", "postgres:16", "running")
+	d.addContainer("cccc", "job", "busybox", "exited")
+	d.addContainer("dddd", "new", "busybox", "created")
+	d.addContainer("eeee", "frozen", "busybox", "paused")
+
+	// the host metrics count all containers regardless of the filters
+	t.Setenv("DEX_EXCLUDE_CONTAINERS", ".*")
+
+	families := gather(t, newTestCollector(t))
+
+	for name, want := range map[string]float64{
+		"dex_host_containers_total":   5,
+		"dex_host_containers_running": 2,
+		"dex_host_containers_stopped": 2,
+		"dex_host_containers_paused":  1,
+	} {
+		if got := metricValue(t, families, name, nil); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestEffectiveMemoryUsage(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		stats container.MemoryStats
+		want  uint64
+	}{
+		{
+			name:  "cgroup v2 inactive_file",
+			stats: container.MemoryStats{Usage: 1000, Stats: map[string]uint64{"inactive_file": 300, "cache": 500}},
+			want:  700,
+		},
+		{
+			name:  "cgroup v1 cache",
+			stats: container.MemoryStats{Usage: 1000, Stats: map[string]uint64{"cache": 500}},
+			want:  500,
+		},
+		{
+			name:  "raw usage",
+			stats: container.MemoryStats{Usage: 1000},
+			want:  1000,
+		},
+		{
+			name:  "cache larger than usage",
+			stats: container.MemoryStats{Usage: 1000, Stats: map[string]uint64{"inactive_file": 2000}},
+			want:  1000,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := effectiveMemoryUsage(tc.stats); got != tc.want {
+				t.Errorf("effectiveMemoryUsage() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCollect_ScrapeTimeout(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "slow", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) { d.statsDelay = 5 * time.Second })
+
+	t.Setenv("DEX_SCRAPE_TIMEOUT", "100ms")
+
+	start := time.Now()
+
+	families := gather(t, newTestCollector(t))
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scrape took %v, the timeout didn't fire", elapsed)
+	}
+
+	if got := metricValue(t, families, "dex_scrape_errors_total", map[string]string{"container_name": "slow", "error_type": "timeout"}); got != 1 {
+		t.Errorf("dex_scrape_errors_total with error_type timeout = %v, want 1", got)
+	}
+}
+
+func TestCollect_ContainerDataContext(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	t.Setenv("DEX_SCRAPE_TIMEOUT", "10s")
+
+	c := newTestCollector(t)
+
+	var deadline time.Time
+
+	c.AddCollector(MetricCollectorFunc(func(_ chan<- prometheus.Metric, data *ContainerData) {
+		deadline, _ = data.Ctx.Deadline()
+	}))
+
+	gather(t, c)
+
+	// the API calls of the collectors are bounded by the scrape timeout
+	if remaining := time.Until(deadline); remaining <= 0 || remaining > 10*time.Second {
+		t.Errorf("deadline of the container data context is %v, want the scrape deadline", deadline)
+	}
+}
+
+func TestNewDockerCollector_MetricPrefixPerCollector(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	t.Setenv("DEX_METRIC_PREFIX", "foo")
+	foo := newTestCollector(t)
+
+	t.Setenv("DEX_METRIC_PREFIX", "bar")
+	bar := newTestCollector(t)
+
+	for prefix, c := range map[string]*DockerCollector{"foo": foo, "bar": bar} {
+		families := gather(t, c)
+
+		for _, name := range []string{"_host_containers_total", "_container_running", "_cpu_utilization_percent"} {
+			if findMetric(families, prefix+name, nil) == nil {
+				t.Errorf("metric %s of the collector with prefix %s is missing", prefix+name, prefix)
+			}
+		}
+
+		for _, family := range families {
+			if !strings.HasPrefix(family.GetName(), prefix+"_") {
+				t.Errorf("metric %s of the collector with prefix %s", family.GetName(), prefix)
+			}
+		}
+	}
+}
+
+func TestInspectContainers_Concurrency(t *testing.T) {
+	d := newFakeDaemon(t)
+	for _, id := range []string{"aaaa", "bbbb", "cccc", "dddd", "eeee", "ffff"} {
+		d.addContainer(id, "c"+id, "busybox", "exited")
+	}
+	d.update(func(d *fakeDaemon) { d.inspectDelay = 20 * time.Millisecond })
+
+	t.Setenv("DEX_SCRAPE_CONCURRENCY", "2")
+
+	families := gather(t, newTestCollector(t))
+
+	if findMetric(families, "dex_container_running", map[string]string{"container_name": "cffff"}) == nil {
+		t.Error("metrics of the last container are missing")
+	}
+
+	d.update(func(d *fakeDaemon) {
+		if d.maxInspecting > 2 {
+			t.Errorf("%d concurrent container inspect calls, want at most 2", d.maxInspecting)
+		}
+	})
+}
+
+func TestHealthLogMetrics_CountsFailuresOnce(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// health check log with results ending at the given seconds after start
+	healthLog := func(results map[int]int) *types.ContainerState {
+		state := &types.ContainerState{Health: &types.Health{}}
+		for second := 0; second < 10; second++ {
+			if exitCode, ok := results[second]; ok {
+				state.Health.Log = append(state.Health.Log, &types.HealthcheckResult{End: start.Add(time.Duration(second) * time.Second), ExitCode: exitCode})
+			}
+		}
+
+		return state
+	}
+
+	c := &DockerCollector{}
+
+	for _, tc := range []struct {
+		state *types.ContainerState
+		want  float64
+	}{
+		{healthLog(map[int]int{0: 1, 1: 0, 2: 1}), 2},
+		// the log is unchanged
+		{healthLog(map[int]int{0: 1, 1: 0, 2: 1}), 2},
+		// old failures were dropped from the log
+		{healthLog(map[int]int{2: 1, 3: 0, 4: 0, 5: 1}), 3},
+		{healthLog(map[int]int{5: 1, 6: 0}), 3},
+	} {
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.healthLogMetrics(ch, tc.state, "aaaa", "web")
+		}))
+
+		if got := metricValue(t, families, "dex_container_health_check_failure_total", map[string]string{"container_name": "web"}); got != tc.want {
+			t.Errorf("dex_container_health_check_failure_total = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestSwapLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		memory     int64
+		memorySwap int64
+		want       float64
+	}{
+		{"unlimited", 1000, -1, math.Inf(1)},
+		{"disabled", 1000, 1000, 0},
+		{"limited", 1000, 3000, 2000},
+		{"default with memory limit", 1000, 0, 1000},
+		{"default without memory limit", 0, 0, math.Inf(1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := swapLimit(&container.HostConfig{Resources: container.Resources{Memory: tc.memory, MemorySwap: tc.memorySwap}}); got != tc.want {
+				t.Errorf("swapLimit() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryMetrics_Swap(t *testing.T) {
+	var stats container.StatsResponse
+	stats.MemoryStats = container.MemoryStats{Usage: 1000, Limit: 4000, Stats: map[string]uint64{"swap": 300}}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.memoryMetrics(ch, &stats, &container.HostConfig{Resources: container.Resources{Memory: 4000, MemorySwap: -1}}, 16000, "web")
+	}))
+
+	labels := map[string]string{"container_name": "web"}
+
+	if got := metricValue(t, families, "dex_memory_swap_usage_bytes", labels); got != 300 {
+		t.Errorf("dex_memory_swap_usage_bytes = %v, want 300", got)
+	}
+
+	if got := metricValue(t, families, "dex_container_swap_limit_bytes", labels); !math.IsInf(got, 1) {
+		t.Errorf("dex_container_swap_limit_bytes = %v, want +Inf", got)
+	}
+}
+
+func TestMemoryMetrics_SwapAccounting(t *testing.T) {
+	tests := []struct {
+		name      string
+		stats     map[string]uint64
+		wantUsage float64
+		wantLimit float64
+		missing   bool
+	}{
+		{
+			name:      "limited",
+			stats:     map[string]uint64{"swap": 300, "hierarchical_memory_limit": 2000, "hierarchical_memsw_limit": 3000},
+			wantUsage: 300,
+			wantLimit: 1000,
+		},
+		{
+			name:      "unlimited",
+			stats:     map[string]uint64{"swap": 0, "hierarchical_memory_limit": 2000, "hierarchical_memsw_limit": unlimitedCgroupLimit},
+			wantUsage: 0,
+			wantLimit: math.Inf(1),
+		},
+		{
+			name:    "no swap accounting",
+			stats:   map[string]uint64{"total_rss": 100},
+			missing: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.MemoryStats = container.MemoryStats{Usage: 1000, Limit: 2000, Stats: tt.stats}
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.memoryMetrics(ch, &stats, &container.HostConfig{}, 16000, "web")
+			}))
+
+			labels := map[string]string{"container_name": "web"}
+
+			if tt.missing {
+				for _, name := range []string{"dex_memory_swap_usage_bytes", "dex_memory_swap_limit_bytes"} {
+					if findMetric(families, name, labels) != nil {
+						t.Errorf("%s without swap accounting, want none", name)
+					}
+				}
+
+				return
+			}
+
+			if got := metricValue(t, families, "dex_memory_swap_usage_bytes", labels); got != tt.wantUsage {
+				t.Errorf("dex_memory_swap_usage_bytes = %v, want %v", got, tt.wantUsage)
+			}
+
+			if got := metricValue(t, families, "dex_memory_swap_limit_bytes", labels); got != tt.wantLimit {
+				t.Errorf("dex_memory_swap_limit_bytes = %v, want %v", got, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestMemoryLimitNearMetrics_EffectiveUsage(t *testing.T) {
+	c := &DockerCollector{memoryLimitWarnThreshold: 0.9}
+
+	for _, tc := range []struct {
+		stats container.MemoryStats
+		want  float64
+	}{
+		// the page cache doesn't count towards the limit
+		{container.MemoryStats{Usage: 1000, Limit: 1000, Stats: map[string]uint64{"inactive_file": 200}}, 0},
+		{container.MemoryStats{Usage: 1000, Limit: 1000, Stats: map[string]uint64{"inactive_file": 50}}, 1},
+		{container.MemoryStats{Usage: 950, Limit: 1000}, 2},
+	} {
+		var stats container.StatsResponse
+		stats.MemoryStats = tc.stats
+
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.memoryLimitNearMetrics(ch, &stats, "aaaa", "web")
+		}))
+
+		if got := metricValue(t, families, "dex_container_memory_limit_near_total", map[string]string{"container_name": "web"}); got != tc.want {
+			t.Errorf("dex_container_memory_limit_near_total = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestObserveRunDuration_ImageName(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "job", "registry:5000/tools/job:1.2@sha256:abcd", "exited")
+	d.update(func(d *fakeDaemon) {
+		inspect := d.inspects["aaaa"]
+		inspect.State.StartedAt = "2024-01-01T00:00:00Z"
+		inspect.State.FinishedAt = "2024-01-01T00:01:30Z"
+		inspect.State.ExitCode = 3
+		d.inspects["aaaa"] = inspect
+	})
+
+	families := gather(t, newTestCollector(t))
+
+	m := findMetric(families, "dex_container_run_duration_seconds", map[string]string{"exit_code": "3"})
+	if m == nil {
+		t.Fatal("dex_container_run_duration_seconds is missing")
+	}
+
+	// without tag and digest, like the image_name label of the container metrics
+	if !hasLabelValue(m.GetLabel(), "image_name", "registry:5000/tools/job") {
+		t.Errorf("labels = %v, want image_name registry:5000/tools/job", m.GetLabel())
+	}
+
+	if got := m.GetHistogram().GetSampleSum(); got != 90 {
+		t.Errorf("run duration = %v, want 90", got)
+	}
+}
+
+func TestImageMetrics_InspectOncePerScrape(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web1", "nginx:1.25", "running")
+	d.addContainer("bbbb", "web2", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) {
+		for _, id := range []string{"aaaa", "bbbb"} {
+			inspect := d.inspects[id]
+			inspect.Image = "sha256:nginx"
+			d.inspects[id] = inspect
+		}
+	})
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newTestCollector(t))
+
+	for scrapes := 1; scrapes <= 2; scrapes++ {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("can't gather metrics: %v", err)
+		}
+
+		for _, cName := range []string{"web1", "web2"} {
+			if findMetric(families, "dex_container_image_creation_age_days", map[string]string{"container_name": cName}) == nil {
+				t.Errorf("image metrics of %s are missing", cName)
+			}
+		}
+
+		if got := d.requestCount("/images/sha256:nginx/json"); got != scrapes {
+			t.Errorf("%d image inspects after %d scrapes, want one per scrape", got, scrapes)
+		}
+	}
+}
+
+func TestMatchesNameFilter(t *testing.T) {
+	app, sys, db := types.Container{Names: []string{"/app-web"}}, types.Container{Names: []string{"/sys-app"}}, types.Container{Names: []string{"/db"}}
+
+	for _, tc := range []struct {
+		name    string
+		include string
+		exclude string
+		want    map[*types.Container]bool
+	}{
+		{"neither", "", "", map[*types.Container]bool{&app: true, &sys: true, &db: true}},
+		{"only include", "^app", "", map[*types.Container]bool{&app: true, &sys: false, &db: false}},
+		{"only exclude", "", "^sys", map[*types.Container]bool{&app: true, &sys: false, &db: true}},
+		{"both", "^app|^sys-app", "^sys", map[*types.Container]bool{&app: true, &sys: true, &db: false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &DockerCollector{}
+			if tc.include != "" {
+				c.includeContainers = regexp.MustCompile(tc.include)
+			}
+
+			if tc.exclude != "" {
+				c.excludeContainers = regexp.MustCompile(tc.exclude)
+			}
+
+			for cont, want := range tc.want {
+				if got := c.matchesNameFilter(*cont); got != want {
+					t.Errorf("matchesNameFilter(%s) = %v, want %v", cont.Names[0], got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchesLabelPrefixFilter(t *testing.T) {
+	compose := types.Container{Labels: map[string]string{"com.docker.compose.project": "shop"}}
+	acme := types.Container{Labels: map[string]string{"acme.team": "a", "version": "1"}}
+	unlabeled := types.Container{Labels: map[string]string{}}
+
+	for _, tc := range []struct {
+		name     string
+		prefixes []string
+		want     map[*types.Container]bool
+	}{
+		{"empty filter", nil, map[*types.Container]bool{&compose: true, &acme: true, &unlabeled: true}},
+		{"prefix", []string{"com.docker.compose."}, map[*types.Container]bool{&compose: true, &acme: false, &unlabeled: false}},
+		{"multiple prefixes", []string{"com.docker.compose.", "acme."}, map[*types.Container]bool{&compose: true, &acme: true, &unlabeled: false}},
+		{"no match", []string{"io.k8s."}, map[*types.Container]bool{&compose: false, &acme: false, &unlabeled: false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &DockerCollector{labelPrefixFilter: tc.prefixes}
+
+			for cont, want := range tc.want {
+				if got := c.matchesLabelPrefixFilter(*cont); got != want {
+					t.Errorf("matchesLabelPrefixFilter(%v) = %v, want %v", cont.Labels, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFdMetrics_CountsOncePerInterval(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("web", "web", "nginx", "running")
+	d.addContainer("db", "db", "postgres", "running")
+	d.addContainer("cache", "cache", "redis", "running")
+
+	execs := make(map[string]int)
+	d.update(func(d *fakeDaemon) {
+		d.exec = func(containerID string, _ []string) (string, int) {
+			execs[containerID]++
+
+			switch containerID {
+			case "web":
+				return "12\n", 0
+			case "db":
+				return "not a number\n", 0
+			default:
+				return "", 1
+			}
+		}
+	})
+
+	c := newTestCollector(t)
+	c.fdMetricsInterval = time.Hour
+
+	for i := 0; i < 2; i++ {
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			for _, name := range []string{"web", "db", "cache"} {
+				c.fdMetrics(context.Background(), ch, name, name)
+			}
+		}))
+
+		if got := metricValue(t, families, "dex_container_open_file_descriptors", map[string]string{"container_name": "web"}); got != 12 {
+			t.Errorf("dex_container_open_file_descriptors of web = %v, want 12", got)
+		}
+
+		for _, name := range []string{"db", "cache"} {
+			if m := findMetric(families, "dex_container_open_file_descriptors", map[string]string{"container_name": name}); m != nil {
+				t.Errorf("dex_container_open_file_descriptors of %s = %v, want none", name, m.GetGauge().GetValue())
+			}
+		}
+	}
+
+	d.update(func(*fakeDaemon) {
+		for _, name := range []string{"web", "db", "cache"} {
+			if execs[name] != 1 {
+				t.Errorf("%d execs in %s, want 1 within the interval", execs[name], name)
+			}
+		}
+	})
+}
+
+func TestContainerNames_CountsConflictsOnce(t *testing.T) {
+	c := &DockerCollector{nameConflicts: prometheus.NewCounter(prometheus.CounterOpts{Name: "name_conflicts_total"})}
+
+	containers := []types.Container{
+		{ID: "aaaaaaaaaaaaaaaa", Names: []string{"/web"}},
+		{ID: "bbbbbbbbbbbbbbbb", Names: []string{"/Web"}},
+	}
+
+	for i := 0; i < 3; i++ {
+		names := c.containerNames(containers)
+
+		if names["aaaaaaaaaaaaaaaa"] != "web" || names["bbbbbbbbbbbbbbbb"] != "Web_bbbbbbbbbbbb" {
+			t.Fatalf("names = %v, want web and Web_bbbbbbbbbbbb", names)
+		}
+		c.forgetRemovedContainers(containers)
+	}
+
+	if got := testutil.ToFloat64(c.nameConflicts); got != 1 {
+		t.Errorf("name conflicts = %v after 3 scrapes, want 1", got)
+	}
+
+	// another conflicting container is counted as well
+	containers = append(containers, types.Container{ID: "cccccccccccccccc", Names: []string{"/WEB"}})
+	c.containerNames(containers)
+
+	if got := testutil.ToFloat64(c.nameConflicts); got != 2 {
+		t.Errorf("name conflicts = %v after a new conflict, want 2", got)
+	}
+}
+
+func TestReset_NextScrapeBehavesLikeTheFirst(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "ok", "nginx:1.25", "running")
+	d.addContainer("bbbb", "broken", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) { delete(d.stats, "bbbb") })
+
+	c := newTestCollector(t)
+	errorLabels := map[string]string{"container_name": "broken", "error_type": "stats"}
+
+	for _, tc := range []struct {
+		reset       bool
+		wantErrors  float64
+		wantCreated float64
+	}{
+		{reset: false, wantErrors: 1, wantCreated: 2},
+		{reset: false, wantErrors: 2, wantCreated: 2},
+		{reset: true, wantErrors: 1, wantCreated: 4},
+	} {
+		if tc.reset {
+			c.Reset()
+		}
+
+		families := gather(t, c)
+
+		if got := metricValue(t, families, "dex_scrape_errors_total", errorLabels); got != tc.wantErrors {
+			t.Errorf("reset %v: dex_scrape_errors_total = %v, want %v", tc.reset, got, tc.wantErrors)
+		}
+		if got := metricValue(t, families, "dex_container_created_total", nil); got != tc.wantCreated {
+			t.Errorf("reset %v: dex_container_created_total = %v, want %v", tc.reset, got, tc.wantCreated)
+		}
+	}
+}
+
+func TestDaemonMetrics_HostNetworkIgnoresFilters(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx", "running")
+	d.addContainer("bbbb", "proxy", "traefik", "running")
+	d.addContainer("cccc", "monitor", "node-exporter", "running")
+	d.addContainer("dddd", "job", "busybox", "exited")
+	d.update(func(d *fakeDaemon) {
+		for i, mode := range []string{"bridge", "host", "host", "host"} {
+			d.containers[i].HostConfig.NetworkMode = mode
+		}
+	})
+
+	// the excluded container on the host network is still counted
+	t.Setenv("DEX_EXCLUDE_CONTAINERS", "^monitor$")
+
+	families := gather(t, newTestCollector(t))
+
+	if got := metricValue(t, families, "dex_docker_containers_host_network_total", nil); got != 2 {
+		t.Errorf("dex_docker_containers_host_network_total = %v, want 2 running containers on the host network", got)
+	}
+
+	if m := findMetric(families, "dex_container_state", map[string]string{"container_name": "monitor"}); m != nil {
+		t.Error("excluded container monitor is collected")
+	}
+}
+
+func TestUintField(t *testing.T) {
+	withField := struct {
+		Periods      uint64
+		BurstPeriods uint64
+	}{Periods: 10, BurstPeriods: 3}
+
+	withoutField := struct{ Periods uint64 }{Periods: 10}
+
+	tests := []struct {
+		name string
+		v    any
+		want uint64
+	}{
+		{name: "field present", v: withField, want: 3},
+		{name: "field absent", v: withoutField, want: 0},
+		{name: "signed field", v: struct{ BurstPeriods int64 }{BurstPeriods: 3}, want: 0},
+		{name: "no struct", v: uint64(3), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uintField(tt.v, "BurstPeriods"); got != tt.want {
+				t.Errorf("uintField() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageMetrics_LastTagTime(t *testing.T) {
+	lastTag := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "pulled", "nginx:1.25", "running")
+	d.addContainer("bbbb", "built", "app:dev", "running")
+	d.update(func(d *fakeDaemon) {
+		var pulled types.ImageInspect
+		pulled.ID = "sha256:aaaa"
+		pulled.Created = "2024-01-01T00:00:00Z"
+		pulled.Metadata.LastTagTime = lastTag
+		d.images["sha256:aaaa"] = pulled
+
+		// locally built images have no tag time
+		d.images["sha256:bbbb"] = types.ImageInspect{ID: "sha256:bbbb", Created: "2024-01-01T00:00:00Z"}
+	})
+
+	families := gather(t, newTestCollector(t))
+
+	labels := map[string]string{"container_name": "pulled", "image_id": "sha256:aaaa"}
+	if got := metricValue(t, families, "dex_container_image_last_pull_timestamp_seconds", labels); got != float64(lastTag.Unix()) {
+		t.Errorf("dex_container_image_last_pull_timestamp_seconds = %v, want %v", got, lastTag.Unix())
+	}
+
+	for _, name := range []string{"dex_container_image_last_pull_timestamp_seconds", "dex_container_image_freshness_days"} {
+		if findMetric(families, name, map[string]string{"container_name": "built"}) != nil {
+			t.Errorf("%s of an image without tag time, want none", name)
+		}
+	}
+
+	if findMetric(families, "dex_container_image_freshness_days", map[string]string{"container_name": "pulled"}) == nil {
+		t.Error("dex_container_image_freshness_days of the pulled image is missing")
+	}
+}
+
+func TestStaleMetrics_Threshold(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	t.Setenv("DEX_STALE_THRESHOLD_SECONDS", "1")
+
+	c := newTestCollector(t)
+	if c.staleThreshold != time.Second {
+		t.Fatalf("stale threshold = %v, want 1s", c.staleThreshold)
+	}
+
+	labels := map[string]string{"container_name": "web"}
+	stats := d.stats["aaaa"]
+
+	assertStale := func(step string, want float64) {
+		t.Helper()
+
+		if got := metricValue(t, gather(t, c), "dex_container_stale", labels); got != want {
+			t.Errorf("%s: dex_container_stale = %v, want %v", step, got, want)
+		}
+	}
+
+	assertStale("stats", 0)
+
+	d.update(func(d *fakeDaemon) { delete(d.stats, "aaaa") })
+	assertStale("failed stats within the threshold", 0)
+
+	// the last successful stats are older than the threshold
+	c.lastStatsTime.Store("aaaa", time.Now().Add(-2*time.Second))
+	assertStale("failed stats after the threshold", 1)
+
+	d.update(func(d *fakeDaemon) { d.stats["aaaa"] = stats })
+	assertStale("recovered stats", 0)
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		ref      string
+		wantName string
+		wantTag  string
+	}{
+		{ref: "nginx", wantName: "nginx", wantTag: "latest"},
+		{ref: "nginx:1.25", wantName: "nginx", wantTag: "1.25"},
+		{ref: "ghcr.io/0xerr0r/dex:v1", wantName: "ghcr.io/0xerr0r/dex", wantTag: "v1"},
+		{ref: "nginx@sha256:abcd", wantName: "nginx", wantTag: "sha256:abcd"},
+		{ref: "nginx:1.25@sha256:abcd", wantName: "nginx", wantTag: "1.25"},
+		{ref: "registry.local:5000/team/app:2.0", wantName: "registry.local:5000/team/app", wantTag: "2.0"},
+		{ref: "registry.local:5000/team/app", wantName: "registry.local:5000/team/app", wantTag: "latest"},
+		{ref: "sha256:abcd", wantName: "sha256:abcd", wantTag: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			name, tag := parseImageRef(tt.ref)
+			if name != tt.wantName || tag != tt.wantTag {
+				t.Errorf("parseImageRef(%q) = %q, %q, want %q, %q", tt.ref, name, tag, tt.wantName, tt.wantTag)
+			}
+		})
+	}
+}
+
+func TestMemoryMetrics_PageFaults(t *testing.T) {
+	var stats container.StatsResponse
+	stats.MemoryStats = container.MemoryStats{Usage: 1000, Limit: 4000, Stats: map[string]uint64{"pgfault": 12345, "pgmajfault": 67}}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.memoryMetrics(ch, &stats, &container.HostConfig{}, 16000, "web")
+	}))
+
+	for name, want := range map[string]float64{
+		"dex_container_memory_pgfault_total":    12345,
+		"dex_container_memory_pgmajfault_total": 67,
+	} {
+		m := findMetric(families, name, map[string]string{"container_name": "web"})
+		if m == nil {
+			t.Errorf("%s is missing", name)
+			continue
+		}
+
+		if m.GetCounter() == nil {
+			t.Errorf("%s is no counter", name)
+		} else if got := m.GetCounter().GetValue(); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestPidsMetrics_ConfiguredMax(t *testing.T) {
+	tests := []struct {
+		name       string
+		pidsLimit  *int64
+		wantPidMax float64
+	}{
+		{name: "not configured", pidsLimit: nil, wantPidMax: 0},
+		{name: "unlimited", pidsLimit: int64Ptr(-1), wantPidMax: 0},
+		{name: "zero", pidsLimit: int64Ptr(0), wantPidMax: 0},
+		{name: "limited", pidsLimit: int64Ptr(1000), wantPidMax: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.PidsStats.Current = 12
+
+			hostConfig := &container.HostConfig{}
+			hostConfig.PidsLimit = tt.pidsLimit
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.pidsMetrics(ch, &stats, hostConfig, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_pids_max", map[string]string{"container_name": "web"}); got != tt.wantPidMax {
+				t.Errorf("dex_container_pids_max = %v, want %v", got, tt.wantPidMax)
+			}
+		})
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestTopMetrics_ProcessCount(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "broken", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) {
+		d.top["aaaa"] = [][]string{{"1", "nginx: master"}, {"7", "nginx: worker"}, {"8", "nginx: worker"}}
+		d.top["bbbb"] = nil
+	})
+
+	families := gather(t, newTestCollector(t))
+
+	if got := metricValue(t, families, "dex_container_process_count", map[string]string{"container_name": "web"}); got != 3 {
+		t.Errorf("dex_container_process_count of web = %v, want 3", got)
+	}
+
+	if findMetric(families, "dex_container_process_count", map[string]string{"container_name": "broken"}) != nil {
+		t.Error("dex_container_process_count of a failed top call, want none")
+	}
+
+	if got := metricValue(t, families, "dex_scrape_errors_total", map[string]string{"container_name": "broken", "error_type": "top"}); got != 1 {
+		t.Errorf("dex_scrape_errors_total of the failed top call = %v, want 1", got)
+	}
+}
+
+func TestNetworkMetrics_TotalsOverInterfaces(t *testing.T) {
+	var stats container.StatsResponse
+	stats.Networks = map[string]container.NetworkStats{
+		"eth0": {RxBytes: 100, TxBytes: 10},
+		"eth1": {RxBytes: 200, TxBytes: 20},
+		"eth2": {RxBytes: 300, TxBytes: 30},
+	}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.networkMetrics(ch, &stats, &container.HostConfig{}, "web")
+	}))
+
+	labels := map[string]string{"container_name": "web"}
+
+	for _, tc := range []struct {
+		total        string
+		perInterface string
+		want         float64
+	}{
+		{"dex_container_network_total_rx_bytes_total", "dex_network_rx_bytes_total", 600},
+		{"dex_container_network_total_tx_bytes_total", "dex_network_tx_bytes_total", 60},
+	} {
+		var sum float64
+		for iface := range stats.Networks {
+			sum += metricValue(t, families, tc.perInterface, map[string]string{"container_name": "web", "interface": iface})
+		}
+
+		got := metricValue(t, families, tc.total, labels)
+		if got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.total, got, tc.want)
+		}
+		if got != sum {
+			t.Errorf("%s = %v, want the sum %v of %s", tc.total, got, sum, tc.perInterface)
+		}
+	}
+}
+
+// gatherMemoryMetrics collects the memory metrics of the container "web" on a host with 16000 bytes of memory
+func gatherMemoryMetrics(t *testing.T, memoryStats container.MemoryStats, hostConfig *container.HostConfig) []*dto.MetricFamily {
+	t.Helper()
+
+	var stats container.StatsResponse
+	stats.MemoryStats = memoryStats
+
+	c := &DockerCollector{}
+
+	return gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.memoryMetrics(ch, &stats, hostConfig, 16000, "web")
+	}))
+}
+
+func TestMemoryMetrics_SoftLimit(t *testing.T) {
+	labels := map[string]string{"container_name": "web"}
+	memoryStats := container.MemoryStats{Usage: 1000, Limit: 4000}
+
+	configured := &container.HostConfig{}
+	configured.MemoryReservation = 2000
+
+	families := gatherMemoryMetrics(t, memoryStats, configured)
+	if got := metricValue(t, families, "dex_container_memory_limit_soft_bytes", labels); got != 2000 {
+		t.Errorf("dex_container_memory_limit_soft_bytes = %v, want 2000", got)
+	}
+
+	families = gatherMemoryMetrics(t, memoryStats, &container.HostConfig{})
+	if m := findMetric(families, "dex_container_memory_limit_soft_bytes", labels); m != nil {
+		t.Errorf("dex_container_memory_limit_soft_bytes = %v without memory reservation, want none", m.GetGauge().GetValue())
+	}
+}
+
+func TestNetworkMetrics_StatsMissingReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostConfig *container.HostConfig
+		want       string
+	}{
+		{name: "host network", hostConfig: &container.HostConfig{NetworkMode: "host"}, want: "host_network"},
+		{name: "no network", hostConfig: &container.HostConfig{NetworkMode: "none"}, want: "no_network"},
+		{name: "bridge network", hostConfig: &container.HostConfig{NetworkMode: "bridge"}, want: "unknown"},
+		{name: "no host config", hostConfig: nil, want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.networkMetrics(ch, &stats, tt.hostConfig, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_network_stats_missing", map[string]string{"container_name": "web", "reason": tt.want}); got != 1 {
+				t.Errorf("dex_container_network_stats_missing{reason=%q} = %v, want 1", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAttachMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		config container.Config
+		want   map[string]string
+	}{
+		{
+			name:   "all",
+			config: container.Config{AttachStdin: true, AttachStdout: true, AttachStderr: true},
+			want:   map[string]string{"stdin": "true", "stdout": "true", "stderr": "true"},
+		},
+		{
+			name:   "none",
+			config: container.Config{},
+			want:   map[string]string{"stdin": "false", "stdout": "false", "stderr": "false"},
+		},
+		{
+			name:   "partial",
+			config: container.Config{AttachStdout: true, AttachStderr: true},
+			want:   map[string]string{"stdin": "false", "stdout": "true", "stderr": "true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.attachMetrics(ch, &tt.config, "web")
+			}))
+
+			for stream, attached := range tt.want {
+				labels := map[string]string{"container_name": "web", "stream": stream, "attached": attached}
+				if got := metricValue(t, families, "dex_container_attach_info", labels); got != 1 {
+					t.Errorf("dex_container_attach_info%v = %v, want 1", labels, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryMetrics_OomScoreAdj(t *testing.T) {
+	for _, adj := range []int{-500, 0, 1000} {
+		hostConfig := &container.HostConfig{OomScoreAdj: adj}
+
+		families := gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000}, hostConfig)
+
+		if got := metricValue(t, families, "dex_container_oom_score_adj", map[string]string{"container_name": "web"}); got != float64(adj) {
+			t.Errorf("dex_container_oom_score_adj = %v, want %d", got, adj)
+		}
+	}
+}
+
+func TestBlkioLimitMetrics_DeviceLabel(t *testing.T) {
+	// device numbers are only resolved on linux, /dev/null is the character device 1:3
+	devNull := "/dev/null"
+	if runtime.GOOS == "linux" {
+		devNull = "1:3"
+	}
+
+	hostConfig := &container.HostConfig{}
+	hostConfig.BlkioDeviceReadBps = []*blkiodev.ThrottleDevice{{Path: "/dev/null", Rate: 1000}}
+	hostConfig.BlkioDeviceWriteBps = []*blkiodev.ThrottleDevice{{Path: "/dev/does-not-exist", Rate: 2000}}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.blkioLimitMetrics(ch, hostConfig, "web")
+	}))
+
+	for _, tc := range []struct {
+		name   string
+		device string
+		want   float64
+	}{
+		{"dex_container_device_read_bps_limit", devNull, 1000},
+		// unresolvable devices are labeled with their path
+		{"dex_container_device_write_bps_limit", "/dev/does-not-exist", 2000},
+	} {
+		if got := metricValue(t, families, tc.name, map[string]string{"container_name": "web", "device": tc.device}); got != tc.want {
+			t.Errorf("%s{device=%q} = %v, want %v", tc.name, tc.device, got, tc.want)
+		}
+	}
+}
+
+func TestBlkioLimitMetrics_IopsLimits(t *testing.T) {
+	configured := &container.HostConfig{}
+	configured.BlkioDeviceReadIOps = []*blkiodev.ThrottleDevice{{Path: "/dev/sda", Rate: 500}}
+	configured.BlkioDeviceWriteIOps = []*blkiodev.ThrottleDevice{{Path: "/dev/sda", Rate: 250}, {Path: "/dev/sdb", Rate: 100}}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.blkioLimitMetrics(ch, configured, "limited")
+		c.blkioLimitMetrics(ch, &container.HostConfig{}, "unlimited")
+	}))
+
+	for _, tc := range []struct {
+		name   string
+		device string
+		want   float64
+	}{
+		{"dex_container_device_read_iops_limit", "/dev/sda", 500},
+		{"dex_container_device_write_iops_limit", "/dev/sda", 250},
+		{"dex_container_device_write_iops_limit", "/dev/sdb", 100},
+	} {
+		if got := metricValue(t, families, tc.name, map[string]string{"container_name": "limited", "device": tc.device}); got != tc.want {
+			t.Errorf("%s{device=%q} = %v, want %v", tc.name, tc.device, got, tc.want)
+		}
+	}
+
+	for _, name := range []string{"dex_container_device_read_iops_limit", "dex_container_device_write_iops_limit"} {
+		if findMetric(families, name, map[string]string{"container_name": "unlimited"}) != nil {
+			t.Errorf("%s without configured limit, want none", name)
+		}
+	}
+}
+
+func TestStopMetrics_Signal(t *testing.T) {
+	for stopSignal, want := range map[string]string{
+		"SIGQUIT": "SIGQUIT",
+		"":        "SIGTERM",
+		"15":      "15",
+	} {
+		c := &DockerCollector{}
+
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.stopMetrics(ch, &container.Config{StopSignal: stopSignal}, "web")
+		}))
+
+		if got := metricValue(t, families, "dex_container_stop_signal_info", map[string]string{"container_name": "web", "signal": want}); got != 1 {
+			t.Errorf("dex_container_stop_signal_info{signal=%q} of StopSignal %q = %v, want 1", want, stopSignal, got)
+		}
+	}
+}
+
+func TestStopMetrics_Timeout(t *testing.T) {
+	tenSeconds, twoMinutes := 10, 120
+
+	for _, tc := range []struct {
+		stopTimeout *int
+		want        float64
+	}{
+		{nil, 10},
+		{&tenSeconds, 10},
+		{&twoMinutes, 120},
+	} {
+		c := &DockerCollector{}
+
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.stopMetrics(ch, &container.Config{StopTimeout: tc.stopTimeout}, "web")
+		}))
+
+		if got := metricValue(t, families, "dex_container_stop_timeout_seconds", map[string]string{"container_name": "web"}); got != tc.want {
+			t.Errorf("dex_container_stop_timeout_seconds = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestCPUMetrics_PercentLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostConfig container.HostConfig
+		hostCPUs   int
+		want       float64
+	}{
+		{name: "two of eight CPUs", hostConfig: container.HostConfig{Resources: container.Resources{CPUQuota: 200000, CPUPeriod: 100000}}, hostCPUs: 8, want: 25},
+		{name: "half of four CPUs", hostConfig: container.HostConfig{Resources: container.Resources{CPUQuota: 50000}}, hostCPUs: 4, want: 12.5},
+		{name: "nano CPUs", hostConfig: container.HostConfig{Resources: container.Resources{NanoCPUs: 3_000_000_000}}, hostCPUs: 12, want: 25},
+		{name: "all CPUs", hostConfig: container.HostConfig{Resources: container.Resources{NanoCPUs: 4_000_000_000}}, hostCPUs: 4, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.CPUMetrics(ch, &stats, &tt.hostConfig, tt.hostCPUs, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_cpu_percent_limit", map[string]string{"container_name": "web"}); got != tt.want {
+				t.Errorf("dex_container_cpu_percent_limit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		var stats container.StatsResponse
+		c := &DockerCollector{}
+
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.CPUMetrics(ch, &stats, &container.HostConfig{}, 8, "web")
+		}))
+
+		if findMetric(families, "dex_container_cpu_percent_limit", map[string]string{"container_name": "web"}) != nil {
+			t.Error("dex_container_cpu_percent_limit without CPU limit, want none")
+		}
+	})
+}
+
+func TestMemoryMetrics_PercentLimit(t *testing.T) {
+	labels := map[string]string{"container_name": "web"}
+	memoryStats := container.MemoryStats{Usage: 1000, Limit: 4000}
+
+	limited := &container.HostConfig{}
+	limited.Memory = 4000
+
+	// 4000 of 16000 bytes host memory
+	families := gatherMemoryMetrics(t, memoryStats, limited)
+	if got := metricValue(t, families, "dex_container_memory_percent_limit", labels); got != 25 {
+		t.Errorf("dex_container_memory_percent_limit = %v, want 25", got)
+	}
+
+	families = gatherMemoryMetrics(t, memoryStats, &container.HostConfig{})
+	if findMetric(families, "dex_container_memory_percent_limit", labels) != nil {
+		t.Error("dex_container_memory_percent_limit without memory limit, want none")
+	}
+}
+
+func TestMemoryMetrics_OomKillDisable(t *testing.T) {
+	disabled, enabled := true, false
+
+	for _, tc := range []struct {
+		oomKillDisable *bool
+		want           float64
+	}{
+		{nil, 0},
+		{&disabled, 1},
+		{&enabled, 0},
+	} {
+		hostConfig := &container.HostConfig{}
+		hostConfig.OomKillDisable = tc.oomKillDisable
+
+		families := gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000}, hostConfig)
+
+		if got := metricValue(t, families, "dex_container_oom_kill_disable", map[string]string{"container_name": "web"}); got != tc.want {
+			t.Errorf("dex_container_oom_kill_disable = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestCPUWeightMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources container.Resources
+		want      float64
+	}{
+		// 2 of 8 host CPUs
+		{name: "quota", resources: container.Resources{NanoCPUs: 2_000_000_000}, want: 0.25},
+		// 1024 of 4096 shares of all running containers
+		{name: "shares", resources: container.Resources{CPUShares: 1024}, want: 0.25},
+		// equal share of the 5 running containers
+		{name: "unlimited", resources: container.Resources{}, want: 0.2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scrape := &scrapeInfo{runningContainers: 5, totalCPUShares: 4096}
+			scrape.NCPU = 8
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.cpuWeightMetrics(ch, &container.HostConfig{Resources: tt.resources}, scrape, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_cpu_weight", map[string]string{"container_name": "web"}); got != tt.want {
+				t.Errorf("dex_container_cpu_weight = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAverageMetrics(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		throttledPeriods uint64
+		want             float64
+	}{
+		{name: "unthrottled", throttledPeriods: 0, want: 0},
+		// half of the periods throttled on 4 CPUs is a demand of 2 CPUs, smoothed over 10 seconds
+		{name: "throttled", throttledPeriods: 50, want: 2 * (1 - math.Exp(-1))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &DockerCollector{}
+
+			var got float64
+			for i, sample := range []struct {
+				read             time.Time
+				periods          uint64
+				throttledPeriods uint64
+			}{
+				{start, 1000, 10},
+				{start.Add(10 * time.Second), 1100, 10 + tt.throttledPeriods},
+			} {
+				var stats container.StatsResponse
+				stats.Read = sample.read
+				stats.CPUStats.OnlineCPUs = 4
+				stats.CPUStats.ThrottlingData = container.ThrottlingData{Periods: sample.periods, ThrottledPeriods: sample.throttledPeriods}
+
+				families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+					c.loadAverageMetrics(ch, &stats, "aaaa", "web")
+				}))
+
+				got = metricValue(t, families, "dex_container_cpu_load_average_10s", map[string]string{"container_name": "web"})
+				if i == 0 && got != 0 {
+					t.Errorf("dex_container_cpu_load_average_10s of the first sample = %v, want 0", got)
+				}
+			}
+
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("dex_container_cpu_load_average_10s = %v, want %v", got, tt.want)
+			}
+			if tt.throttledPeriods > 0 && got <= 0 {
+				t.Errorf("dex_container_cpu_load_average_10s of a throttled container = %v, want > 0", got)
+			}
+		})
+	}
+}
+
+func TestCPUMetrics_Deltas(t *testing.T) {
+	var stats container.StatsResponse
+	stats.PreCPUStats.CPUUsage.TotalUsage = 4_000_000_000
+	stats.PreCPUStats.SystemUsage = 100_000_000_000
+	stats.CPUStats.CPUUsage.TotalUsage = 4_500_000_000
+	stats.CPUStats.SystemUsage = 104_000_000_000
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.CPUMetrics(ch, &stats, &container.HostConfig{}, 4, "web")
+	}))
+
+	labels := map[string]string{"container_name": "web"}
+
+	for name, want := range map[string]float64{
+		"dex_container_cpu_usage_nanoseconds_delta":  500_000_000,
+		"dex_container_cpu_system_nanoseconds_delta": 4_000_000_000,
+		// the utilization is derived from both deltas
+		"dex_cpu_utilization_percent": 12.5,
+	} {
+		if got := metricValue(t, families, name, labels); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMemoryMetrics_CgroupVersion(t *testing.T) {
+	for _, tc := range []struct {
+		stats map[string]uint64
+		want  string
+	}{
+		{map[string]uint64{"anon": 100, "file": 50}, "2"},
+		{map[string]uint64{"rss": 100, "cache": 50}, "1"},
+		{map[string]uint64{}, "unknown"},
+	} {
+		families := gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000, Stats: tc.stats}, &container.HostConfig{})
+
+		if got := metricValue(t, families, "dex_container_cgroup_version", map[string]string{"container_name": "web", "version": tc.want}); got != 1 {
+			t.Errorf("dex_container_cgroup_version{version=%q} of %v = %v, want 1", tc.want, tc.stats, got)
+		}
+	}
+}
+
+func TestThreadMetrics(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "distroless", "app:1", "running")
+	d.update(func(d *fakeDaemon) {
+		d.exec = func(containerID string, cmd []string) (string, int) {
+			if containerID == "bbbb" {
+				// no shell in the container
+				return "", 127
+			}
+
+			// the status of the executing shell is listed as well
+			return "Threads:\t1\nThreads:\t8\nThreads:\t3\nThreads:\t1\n", 0
+		}
+	})
+
+	c := newTestCollector(t)
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.threadMetrics(context.Background(), ch, "aaaa", "web")
+		c.threadMetrics(context.Background(), ch, "bbbb", "distroless")
+	}))
+
+	if got := metricValue(t, families, "dex_container_thread_count", map[string]string{"container_name": "web"}); got != 12 {
+		t.Errorf("dex_container_thread_count = %v, want 12 without the shell", got)
+	}
+
+	if findMetric(families, "dex_container_thread_count", map[string]string{"container_name": "distroless"}) != nil {
+		t.Error("dex_container_thread_count of a container without shell, want none")
+	}
+}
+
+func TestTcpMetrics(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "ss", "app:1", "running")
+	d.addContainer("bbbb", "netstat", "app:1", "running")
+	d.addContainer("cccc", "none", "app:1", "running")
+	d.update(func(d *fakeDaemon) {
+		d.exec = func(containerID string, cmd []string) (string, int) {
+			switch {
+			case containerID == "aaaa" && cmd[0] == "ss":
+				return "Recv-Q Send-Q Local Address:Port Peer Address:Port\n" +
+					"0      0      172.17.0.2:8080    172.17.0.1:51000\n" +
+					"0      0      172.17.0.2:8080    172.17.0.1:51002\n", 0
+			case containerID == "bbbb" && cmd[0] == "netstat":
+				return "Active Internet connections (w/o servers)\n" +
+					"Proto Recv-Q Send-Q Local Address           Foreign Address         State\n" +
+					"tcp        0      0 172.17.0.3:5432         172.17.0.2:40000        ESTABLISHED\n" +
+					"tcp        0      0 172.17.0.3:5432         172.17.0.2:40002        ESTABLISHED\n" +
+					"tcp        0      0 172.17.0.3:5432         172.17.0.2:40004        TIME_WAIT\n" +
+					"tcp        0      0 172.17.0.3:5432         172.17.0.2:40006        ESTABLISHED\n", 0
+			default:
+				return "", 127
+			}
+		}
+	})
+
+	c := newTestCollector(t)
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		for id, name := range map[string]string{"aaaa": "ss", "bbbb": "netstat", "cccc": "none"} {
+			c.tcpMetrics(context.Background(), ch, id, name)
+		}
+	}))
+
+	for name, want := range map[string]float64{"ss": 2, "netstat": 3} {
+		if got := metricValue(t, families, "dex_container_tcp_connections_established", map[string]string{"container_name": name}); got != want {
+			t.Errorf("dex_container_tcp_connections_established of %s = %v, want %v", name, got, want)
+		}
+	}
+
+	if findMetric(families, "dex_container_tcp_connections_established", map[string]string{"container_name": "none"}) != nil {
+		t.Error("dex_container_tcp_connections_established without ss and netstat, want none")
+	}
+}
+
+func TestFsMetrics_Sizes(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) {
+		inspect := d.inspects["aaaa"]
+		inspect.SizeRw = int64Ptr(4096)
+		inspect.SizeRootFs = int64Ptr(190 << 20)
+		d.inspects["aaaa"] = inspect
+	})
+
+	c := newTestCollector(t)
+	c.fsMetricsInterval = time.Hour
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.fsMetrics(context.Background(), ch, "aaaa", "web")
+	}))
+
+	labels := map[string]string{"container_name": "web"}
+
+	if got := metricValue(t, families, "dex_container_fs_rw_bytes", labels); got != 4096 {
+		t.Errorf("dex_container_fs_rw_bytes = %v, want 4096", got)
+	}
+	if got := metricValue(t, families, "dex_container_fs_total_bytes", labels); got != 190<<20 {
+		t.Errorf("dex_container_fs_total_bytes = %v, want %v", got, 190<<20)
+	}
+}
+
+func TestStorageDriverMetrics(t *testing.T) {
+	for driver, want := range map[string]string{
+		"overlay2": "overlay2",
+		"aufs":     "aufs",
+		"":         "unknown",
+	} {
+		c := &DockerCollector{}
+
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.storageDriverMetrics(ch, driver, "web")
+		}))
+
+		if got := metricValue(t, families, "dex_container_storage_driver_info", map[string]string{"container_name": "web", "driver": want}); got != 1 {
+			t.Errorf("dex_container_storage_driver_info{driver=%q} of driver %q = %v, want 1", want, driver, got)
+		}
+	}
+}
+
+func TestCPUHistogramMetrics_Buckets(t *testing.T) {
+	var stats container.StatsResponse
+	stats.CPUStats.OnlineCPUs = 4
+	stats.PreCPUStats.SystemUsage = 10_000_000_000
+	stats.CPUStats.SystemUsage = 14_000_000_000
+	stats.PreCPUStats.CPUUsage.PercpuUsage = []uint64{1_000_000_000, 1_000_000_000, 1_000_000_000, 1_000_000_000}
+	// 0%, 20%, 60% and 100% of a core
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{1_000_000_000, 1_200_000_000, 1_600_000_000, 2_000_000_000}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.cpuHistogramMetrics(ch, &stats, "web")
+	}))
+
+	m := findMetric(families, "dex_container_cpu_per_core_utilization_percent", map[string]string{"container_name": "web"})
+	if m == nil {
+		t.Fatal("dex_container_cpu_per_core_utilization_percent is missing")
+	}
+
+	histogram := m.GetHistogram()
+	if histogram.GetSampleCount() != 4 || histogram.GetSampleSum() != 180 {
+		t.Errorf("count = %d, sum = %v, want 4 cores with 180%%", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+
+	want := map[float64]uint64{0: 1, 10: 1, 25: 2, 50: 2, 75: 3, 90: 3, 100: 4}
+	for _, bucket := range histogram.GetBucket() {
+		if got := bucket.GetCumulativeCount(); got != want[bucket.GetUpperBound()] {
+			t.Errorf("bucket le=%v = %d, want %d", bucket.GetUpperBound(), got, want[bucket.GetUpperBound()])
+		}
+	}
+
+	if len(histogram.GetBucket()) != len(want) {
+		t.Errorf("%d buckets, want %d", len(histogram.GetBucket()), len(want))
+	}
+}
+
+func TestHandleMemoryEvent_CountsOOMEvents(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "job", "busybox", "exited")
+	d.update(func(d *fakeDaemon) {
+		d.info.CgroupVersion = "2"
+
+		oom := func(id string) events.Message {
+			return events.Message{Type: events.ContainerEventType, Action: events.ActionOOM, Actor: events.Actor{ID: id}}
+		}
+
+		// the die event doesn't match the filter of the OOM subscription
+		d.events = []events.Message{
+			oom("aaaa"), oom("bbbb"), oom("aaaa"),
+			{Type: events.ContainerEventType, Action: events.ActionDie, Actor: events.Actor{ID: "aaaa"}},
+			oom("aaaa"),
+		}
+	})
+
+	t.Setenv("DEX_MEMORY_PRESSURE_EVENTS", "true")
+
+	c := newTestCollector(t)
+
+	waitForMetric(t, c, "dex_container_memory_pressure_events_total", map[string]string{"container_name": "web", "level": "oom"}, 3)
+	waitForMetric(t, c, "dex_container_memory_pressure_events_total", map[string]string{"container_name": "job", "level": "oom"}, 1)
+}
+
+func TestParseMemoryEvents(t *testing.T) {
+	counts := parseMemoryEvents("low 0\nhigh 12\nmax 3\noom 1\noom_kill 1\noom_group_kill 0\ninvalid\nbroken x\n")
+
+	want := map[string]float64{"low": 0, "high": 12, "max": 3, "oom": 1, "oom_kill": 1, "oom_group_kill": 0}
+
+	if len(counts) != len(want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+
+	for key, value := range want {
+		if got, ok := counts[key]; !ok || got != value {
+			t.Errorf("%s = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestInterfaceMetrics_CountsAndTopologyChanges(t *testing.T) {
+	c := &DockerCollector{}
+	labels := map[string]string{"container_name": "web"}
+
+	// interface sets of consecutive scrapes and the expected count and changes
+	for i, tc := range []struct {
+		networks    map[string]container.NetworkStats
+		wantCount   float64
+		wantChanges float64
+	}{
+		{map[string]container.NetworkStats{"eth0": {}}, 1, 0},
+		{map[string]container.NetworkStats{"eth0": {}, "eth1": {}, "eth2": {}}, 3, 1},
+		{map[string]container.NetworkStats{"eth0": {}, "eth1": {}, "eth2": {}}, 3, 1},
+		// e.g. with network mode none
+		{nil, 0, 2},
+		{map[string]container.NetworkStats{"eth0": {}}, 1, 3},
+	} {
+		stats := &container.StatsResponse{Networks: tc.networks}
+
+		families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.interfaceMetrics(ch, stats, "aaaa", "web")
+		}))
+
+		if got := metricValue(t, families, "dex_container_network_interface_count", labels); got != tc.wantCount {
+			t.Errorf("scrape %d: dex_container_network_interface_count = %v, want %v", i, got, tc.wantCount)
+		}
+
+		if got := metricValue(t, families, "dex_container_network_topology_change_total", labels); got != tc.wantChanges {
+			t.Errorf("scrape %d: dex_container_network_topology_change_total = %v, want %v", i, got, tc.wantChanges)
+		}
+	}
+}
+
+func TestCgroupParentMetrics(t *testing.T) {
+	c := &DockerCollector{}
+
+	gatherParent := func(hostConfig *container.HostConfig) []*dto.MetricFamily {
+		return gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.cgroupParentMetrics(ch, hostConfig, "web")
+		}))
+	}
+
+	families := gatherParent(&container.HostConfig{Resources: container.Resources{CgroupParent: "/kubepods/burstable"}})
+
+	if got := metricValue(t, families, "dex_container_cgroup_parent_info", map[string]string{"container_name": "web", "cgroup_parent": "/kubepods/burstable"}); got != 1 {
+		t.Errorf("dex_container_cgroup_parent_info = %v, want 1", got)
+	}
+
+	// the default parent of the daemon is left out
+	for _, hostConfig := range []*container.HostConfig{{}, nil} {
+		if families := gatherParent(hostConfig); len(families) != 0 {
+			t.Errorf("metrics of the default cgroup parent = %v, want none", families)
+		}
+	}
+}
+
+func TestSecurityMetrics_UsernsRemapped(t *testing.T) {
+	c := &DockerCollector{}
+	daemonUserns := []string{"name=seccomp,profile=builtin", "name=userns"}
+
+	for _, tc := range []struct {
+		name           string
+		usernsMode     container.UsernsMode
+		daemonSecurity []string
+		wantRemapped   float64
+	}{
+		{"host", "host", daemonUserns, 0},
+		{"daemon default without userns-remap", "", []string{"name=seccomp,profile=builtin"}, 0},
+		{"daemon default with userns-remap", "", daemonUserns, 1},
+		{"private", "private", nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hostConfig := &container.HostConfig{UsernsMode: tc.usernsMode}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.securityMetrics(ch, hostConfig, tc.daemonSecurity, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_userns_remapped", map[string]string{"container_name": "web"}); got != tc.wantRemapped {
+				t.Errorf("dex_container_userns_remapped = %v, want %v", got, tc.wantRemapped)
+			}
+		})
+	}
+}
+
+func TestKubernetesMetrics_RuntimeClass(t *testing.T) {
+	c := &DockerCollector{}
+
+	gatherKubernetes := func(labels map[string]string) []*dto.MetricFamily {
+		return gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.kubernetesMetrics(ch, labels, "web")
+		}))
+	}
+
+	families := gatherKubernetes(map[string]string{labelK8sRuntimeClass: "gvisor", "io.kubernetes.pod.name": "web-0"})
+
+	if got := metricValue(t, families, "dex_container_runtime_class_info", map[string]string{"container_name": "web", "runtime_class": "gvisor"}); got != 1 {
+		t.Errorf("dex_container_runtime_class_info = %v, want 1", got)
+	}
+
+	for _, labels := range []map[string]string{
+		{"io.kubernetes.pod.name": "web-0"},
+		{labelK8sRuntimeClass: ""},
+		nil,
+	} {
+		if families := gatherKubernetes(labels); len(families) != 0 {
+			t.Errorf("metrics of labels %v = %v, want none", labels, families)
+		}
+	}
+}
+
+func TestMemoryMetrics_SwapFailcnt(t *testing.T) {
+	labels := map[string]string{"container_name": "web"}
+
+	// cgroups v1 with swap accounting
+	families := gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000, Stats: map[string]uint64{"rss": 800, "memsw.failcnt": 7}}, &container.HostConfig{})
+
+	if got := metricValue(t, families, "dex_container_memory_swap_failcnt_total", labels); got != 7 {
+		t.Errorf("dex_container_memory_swap_failcnt_total = %v, want 7", got)
+	}
+
+	// cgroups v1 without swap accounting and cgroups v2
+	for _, stats := range []map[string]uint64{{"rss": 800}, {"anon": 800, "inactive_file": 100}} {
+		families := gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000, Stats: stats}, &container.HostConfig{})
+
+		if findMetric(families, "dex_container_memory_swap_failcnt_total", labels) != nil {
+			t.Errorf("dex_container_memory_swap_failcnt_total of stats %v is emitted, want none", stats)
+		}
+	}
+}
+
+func TestMemoryMetrics_TcpBuffer(t *testing.T) {
+	labels := map[string]string{"container_name": "web"}
+
+	// cgroups v1 with kernel memory accounting
+	families := gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000, Stats: map[string]uint64{"rss": 800, "tcp": 4096}}, &container.HostConfig{})
+
+	if got := metricValue(t, families, "dex_container_memory_tcp_buffer_bytes", labels); got != 4096 {
+		t.Errorf("dex_container_memory_tcp_buffer_bytes = %v, want 4096", got)
+	}
+
+	// cgroups v2 reports the socket memory as sock instead
+	families = gatherMemoryMetrics(t, container.MemoryStats{Usage: 1000, Limit: 4000, Stats: map[string]uint64{"anon": 800, "sock": 4096}}, &container.HostConfig{})
+
+	if findMetric(families, "dex_container_memory_tcp_buffer_bytes", labels) != nil {
+		t.Error("dex_container_memory_tcp_buffer_bytes of cgroups v2 is emitted, want none")
+	}
+}
+
+func TestHandleDieEvent_ExitCodesAndCrashes(t *testing.T) {
+	c := &DockerCollector{}
+	labels := map[string]string{"container_name": "web"}
+
+	die := func(exitCode string) events.Message {
+		return events.Message{
+			Type:   events.ContainerEventType,
+			Action: events.ActionDie,
+			Actor:  events.Actor{ID: "aaaa", Attributes: map[string]string{"exitCode": exitCode}},
+		}
+	}
+
+	gatherDie := func() []*dto.MetricFamily {
+		return gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+			c.dieMetrics(ch, "aaaa", "web")
+		}))
+	}
+
+	if families := gatherDie(); len(families) != 0 {
+		t.Errorf("metrics without die event = %v, want none", families)
+	}
+
+	// consecutive die events and the expected crash counts by reason
+	for _, tc := range []struct {
+		exitCode    string
+		wantExit    float64
+		wantCrashes map[string]float64
+	}{
+		{"0", 0, map[string]float64{}},
+		{"1", 1, map[string]float64{}},
+		{"137", 137, map[string]float64{"sigkill": 1}},
+		{"139", 139, map[string]float64{"sigkill": 1, "segfault": 1}},
+		{"137", 137, map[string]float64{"sigkill": 2, "segfault": 1}},
+		// events without exit code are ignored
+		{"", 137, map[string]float64{"sigkill": 2, "segfault": 1}},
+	} {
+		c.handleDieEvent(die(tc.exitCode))
+
+		families := gatherDie()
+
+		if got := metricValue(t, families, "dex_container_last_die_exit_code", labels); got != tc.wantExit {
+			t.Errorf("exit code %q: dex_container_last_die_exit_code = %v, want %v", tc.exitCode, got, tc.wantExit)
+		}
+
+		for _, reason := range []string{"sigkill", "segfault"} {
+			m := findMetric(families, "dex_container_crash_total", map[string]string{"container_name": "web", "reason": reason})
+
+			want, ok := tc.wantCrashes[reason]
+			switch {
+			case !ok && m != nil:
+				t.Errorf("exit code %q: dex_container_crash_total of %s = %v, want none", tc.exitCode, reason, m.GetCounter().GetValue())
+			case ok && m.GetCounter().GetValue() != want:
+				t.Errorf("exit code %q: dex_container_crash_total of %s = %v, want %v", tc.exitCode, reason, m.GetCounter().GetValue(), want)
+			}
+		}
+	}
+}
+
+func TestTopMetrics_ExecCount(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "shell", "debian", "running")
+	d.addContainer("cccc", "empty", "debian", "running")
+	d.update(func(d *fakeDaemon) {
+		// main process and two execs, e.g. docker exec sh and a debug tool
+		d.top["bbbb"] = [][]string{{"1", "sleep infinity"}, {"12", "sh"}, {"20", "top"}}
+		d.top["cccc"] = [][]string{}
+	})
+
+	t.Setenv("DEX_EXEC_COUNT_METRICS", "true")
+
+	families := gather(t, newTestCollector(t))
+
+	for cName, want := range map[string]float64{"web": 0, "shell": 2, "empty": 0} {
+		if got := metricValue(t, families, "dex_container_exec_count", map[string]string{"container_name": cName}); got != want {
+			t.Errorf("dex_container_exec_count of %s = %v, want %v", cName, got, want)
+		}
+	}
+
+	t.Setenv("DEX_EXEC_COUNT_METRICS", "false")
+
+	if findMetric(gather(t, newTestCollector(t)), "dex_container_exec_count", nil) != nil {
+		t.Error("dex_container_exec_count is emitted although disabled")
+	}
+}
+
+func TestCPUMetrics_CgroupV2(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalUsage  uint64
+		percpuUsage []uint64
+		want        float64
+	}{
+		{name: "cgroups v1", totalUsage: 3000, percpuUsage: []uint64{1000, 2000}, want: 0},
+		// burst periods aren't part of the docker API types in use, see TestUintField
+		{name: "cgroups v2", totalUsage: 3000, want: 1},
+		{name: "unknown", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.CPUStats.CPUUsage.TotalUsage = tt.totalUsage
+			stats.CPUStats.CPUUsage.PercpuUsage = tt.percpuUsage
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.CPUMetrics(ch, &stats, &container.HostConfig{}, 4, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_container_cpu_cgroup_v2", map[string]string{"container_name": "web"}); got != tt.want {
+				t.Errorf("dex_container_cpu_cgroup_v2 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkMetrics_PerInterface(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks map[string]container.NetworkStats
+	}{
+		{name: "multiple interfaces", networks: map[string]container.NetworkStats{
+			"eth0": {RxBytes: 100, TxBytes: 10, RxPackets: 5, TxPackets: 2},
+			"eth1": {RxBytes: 200, TxBytes: 20, RxPackets: 7, TxPackets: 3},
+		}},
+		// e.g. macvlan or custom interface names
+		{name: "without eth0", networks: map[string]container.NetworkStats{
+			"net1": {RxBytes: 300, TxBytes: 30, RxPackets: 11, TxPackets: 4},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.Networks = tt.networks
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.networkMetrics(ch, &stats, &container.HostConfig{}, "web")
+			}))
+
+			for iface, n := range tt.networks {
+				labels := map[string]string{"container_name": "web", "interface": iface}
+
+				for name, want := range map[string]uint64{
+					"dex_network_rx_bytes_total":   n.RxBytes,
+					"dex_network_tx_bytes_total":   n.TxBytes,
+					"dex_network_rx_packets_total": n.RxPackets,
+					"dex_network_tx_packets_total": n.TxPackets,
+				} {
+					if got := metricValue(t, families, name, labels); got != float64(want) {
+						t.Errorf("%s of %s = %v, want %v", name, iface, got, want)
+					}
+				}
+			}
+
+			for _, family := range families {
+				if family.GetName() == "dex_network_rx_bytes_total" && len(family.GetMetric()) != len(tt.networks) {
+					t.Errorf("dex_network_rx_bytes_total has %d interfaces, want %d", len(family.GetMetric()), len(tt.networks))
+				}
+			}
+
+			if _, ok := tt.networks["eth0"]; !ok && findMetric(families, "dex_network_rx_bytes_total", map[string]string{"interface": "eth0"}) != nil {
+				t.Error("dex_network_rx_bytes_total of eth0 is emitted, want none")
+			}
+
+			if findMetric(families, "dex_container_network_stats_missing", nil) != nil {
+				t.Error("dex_container_network_stats_missing is emitted for a container with network stats")
+			}
+		})
+	}
+}
+
+func TestNetworkMetrics_EmitsZeroCounters(t *testing.T) {
+	var stats container.StatsResponse
+	stats.Networks = map[string]container.NetworkStats{"eth0": {RxBytes: 100, TxBytes: 10}}
+
+	c := &DockerCollector{}
+
+	families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.networkMetrics(ch, &stats, &container.HostConfig{}, "web")
+	}))
+
+	labels := map[string]string{"container_name": "web", "interface": "eth0"}
+
+	for _, name := range []string{
+		"dex_network_rx_packets_total", "dex_network_tx_packets_total",
+		"dex_network_rx_errors_total", "dex_network_tx_errors_total",
+		"dex_network_rx_dropped_total", "dex_network_tx_dropped_total",
+	} {
+		if got := metricValue(t, families, name, labels); got != 0 {
+			t.Errorf("%s = %v, want 0", name, got)
+		}
+	}
+}
+
+func TestDockerCollector_ContainerState(t *testing.T) {
+	states := []string{"created", "restarting", "running", "removing", "paused", "exited", "dead"}
+
+	d := newFakeDaemon(t)
+	for i, state := range states {
+		d.addContainer(fmt.Sprintf("%04d", i), state+"-container", "nginx:1.25", state)
+	}
+
+	families := gather(t, newTestCollector(t))
+
+	for _, state := range states {
+		t.Run(state, func(t *testing.T) {
+			cName := state + "-container"
+
+			if got := metricValue(t, families, "dex_container_state", map[string]string{"container_name": cName, "state": state}); got != 1 {
+				t.Errorf("dex_container_state{state=%q} = %v, want 1", state, got)
+			}
+
+			// a single series with the current state
+			var series int
+			for _, family := range families {
+				if family.GetName() != "dex_container_state" {
+					continue
+				}
+
+				for _, m := range family.GetMetric() {
+					if hasLabelValue(m.GetLabel(), "container_name", cName) {
+						series++
+					}
+				}
+			}
+
+			if series != 1 {
+				t.Errorf("%d dex_container_state series, want 1", series)
+			}
+
+			for name, flagState := range map[string]string{
+				"dex_container_running":    "running",
+				"dex_container_restarting": "restarting",
+				"dex_container_exited":     "exited",
+			} {
+				var want float64
+				if state == flagState {
+					want = 1
+				}
+
+				if got := metricValue(t, families, name, map[string]string{"container_name": cName}); got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPidsMetrics_CgroupLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit uint64
+		want  float64
+	}{
+		{name: "limited", limit: 512, want: 512},
+		{name: "unlimited", limit: 0, want: math.Inf(1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats container.StatsResponse
+			stats.PidsStats.Current = 12
+			stats.PidsStats.Limit = tt.limit
+
+			c := &DockerCollector{}
+
+			families := gather(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.pidsMetrics(ch, &stats, &container.HostConfig{}, "web")
+			}))
+
+			if got := metricValue(t, families, "dex_pids_limit", map[string]string{"container_name": "web"}); got != tt.want {
+				t.Errorf("dex_pids_limit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryMetrics_OomKills(t *testing.T) {
+	tests := []struct {
+		name        string
+		memoryStats container.MemoryStats
+		want        float64
+	}{
+		{name: "cgroups v2 oom_kill", memoryStats: container.MemoryStats{Usage: 1000, Limit: 4000, Stats: map[string]uint64{"anon": 800, "oom_kill": 3}}, want: 3},
+		// cgroups v1 only counts how often the limit was hit
+		{name: "cgroups v1 failcnt", memoryStats: container.MemoryStats{Usage: 1000, Limit: 4000, Failcnt: 5, Stats: map[string]uint64{"rss": 800}}, want: 5},
+		{name: "oom_kill preferred", memoryStats: container.MemoryStats{Usage: 1000, Limit: 4000, Failcnt: 5, Stats: map[string]uint64{"oom_kill": 2}}, want: 2},
+		{name: "none", memoryStats: container.MemoryStats{Usage: 1000, Limit: 4000}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gatherMemoryMetrics(t, tt.memoryStats, &container.HostConfig{})
+
+			if got := metricValue(t, families, "dex_memory_oom_kills_total", map[string]string{"container_name": "web"}); got != tt.want {
+				t.Errorf("dex_memory_oom_kills_total = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerCollector_ExitCode(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "done", "busybox", "exited")
+	d.addContainer("cccc", "failed", "busybox", "exited")
+	d.addContainer("dddd", "killed", "busybox", "exited")
+	d.update(func(d *fakeDaemon) {
+		for id, exitCode := range map[string]int{
+			// exit code of the previous run, the container was restarted since
+			"aaaa": 1,
+			"bbbb": 0,
+			"cccc": 1,
+			"dddd": 137,
+		} {
+			d.inspects[id].State.ExitCode = exitCode
+		}
+	})
+
+	families := gather(t, newTestCollector(t))
+
+	for cName, want := range map[string]float64{"web": 0, "done": 0, "failed": 1, "killed": 137} {
+		if got := metricValue(t, families, "dex_container_exit_code", map[string]string{"container_name": cName}); got != want {
+			t.Errorf("dex_container_exit_code of %s = %v, want %v", cName, got, want)
+		}
+	}
+}
+
+func TestHandleImageEvent_PullsAndPushes(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.update(func(d *fakeDaemon) {
+		image := func(action events.Action, id, name string, timestamp int64) events.Message {
+			msg := events.Message{Type: events.ImageEventType, Action: action, Actor: events.Actor{ID: id}, Time: timestamp}
+			if name != "" {
+				msg.Actor.Attributes = map[string]string{"name": name}
+			}
+
+			return msg
+		}
+
+		d.events = []events.Message{
+			image(events.ActionPull, "nginx:1.25", "nginx", 1700000000),
+			image(events.ActionPull, "nginx:1.26", "nginx", 1700000100),
+			// deletes don't match the filter of the subscription
+			image(events.ActionDelete, "nginx:1.25", "nginx", 1700000200),
+			// the name is taken from the reference without name attribute
+			image(events.ActionPush, "registry.example.com/shop:2", "", 1700000300),
+			image(events.ActionPush, "registry.example.com/shop:3", "", 1700000400),
+		}
+	})
+
+	c := newTestCollector(t)
+
+	// the events are handled in order, so the earlier ones are handled after the last push
+	waitForMetric(t, c, "dex_image_push_total", map[string]string{"image_name": "registry.example.com/shop"}, 2)
+
+	families := gather(t, c)
+
+	if got := metricValue(t, families, "dex_image_pull_total", map[string]string{"image_name": "nginx"}); got != 2 {
+		t.Errorf("dex_image_pull_total of nginx = %v, want 2", got)
+	}
+
+	if got := metricValue(t, families, "dex_image_last_pull_timestamp_seconds", map[string]string{"image_name": "nginx"}); got != 1700000100 {
+		t.Errorf("dex_image_last_pull_timestamp_seconds of nginx = %v, want 1700000100", got)
+	}
+
+	if findMetric(families, "dex_image_pull_total", map[string]string{"image_name": "registry.example.com/shop"}) != nil {
+		t.Error("dex_image_pull_total of the pushed image is emitted, want none")
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// valid metric name prefixes
+var metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// DexConfig holds the exporter configuration, read from environment variables
+type DexConfig struct {
+	// HTTP port of the metrics endpoint
+	Port int
+
+	// prefix of all metric names, separated by an underscore
+	MetricPrefix string
+
+	// certificate and key files for serving the metrics endpoint with TLS, plain HTTP if empty
+	TLSCert string
+	TLSKey  string
+
+	// CA file for verifying client certificates (mutual TLS), client certificates are not required if empty
+	TLSClientCA string
+
+	// emit block I/O metrics per device in addition to the container totals
+	BlockIoPerDevice bool
+
+	// only collect containers having a label key with one of these prefixes, all containers if empty
+	LabelPrefixFilter []string
+
+	// containers with a matching name are collected, even if they match ExcludeContainers
+	IncludeContainers *regexp.Regexp
+
+	// containers with a matching name are not collected
+	ExcludeContainers *regexp.Regexp
+
+	// docker label keys added as Prometheus labels to all metrics of the container
+	ExtraLabels []string
+
+	// add the docker compose project and service as Prometheus labels to all metrics of the container
+	ComposeLabels bool
+
+	// running containers without successful stats for this duration are flagged as stale
+	StaleThreshold time.Duration
+
+	// emit the distribution of the CPU utilization over the cores as histogram
+	CPUHistogram bool
+
+	// count memory pressure events of the containers, requires cgroups v2
+	MemoryPressureEvents bool
+
+	// emit the number of processes besides the main process of each running container
+	ExecCountMetrics bool
+
+	// memory usage from this fraction of the limit on counts as near the limit
+	MemoryLimitWarnThreshold float64
+
+	// count threads by executing a command inside each running container
+	ThreadMetrics bool
+
+	// count open file descriptors by executing a command inside each running container
+	FdMetrics bool
+
+	// open file descriptors are counted at most once per interval
+	FdMetricsInterval time.Duration
+
+	// count established TCP connections by executing ss or netstat inside each running container
+	TcpMetrics bool
+
+	// calculate the container filesystem sizes, expensive since docker walks the filesystem
+	FsMetrics bool
+
+	// filesystem sizes are calculated at most once per interval
+	FsMetricsInterval time.Duration
+
+	// maximal number of containers processed per scrape, unlimited if 0
+	MaxContainers int
+
+	// fraction of the running containers whose stats are collected per scrape
+	SamplingRate float64
+
+	// maximal number of containers processed concurrently per scrape
+	ScrapeConcurrency int
+
+	// the docker API calls of a scrape are canceled after this duration
+	ScrapeTimeout time.Duration
+
+	// metrics are served from the cache for this duration, the cache is disabled if 0
+	CacheTTL time.Duration
+
+	// maximal number of label value combinations per metric name and scrape, unlimited if 0
+	MaxCardinality int
+
+	// docker daemon address overriding DOCKER_HOST, e.g. unix:///run/user/1000/docker.sock, a bare
+	// socket path is prefixed with unix://
+	DockerHost string
+
+	// CA, certificate and key files for connecting to DockerHost over TCP with TLS, plain TCP if empty
+	DockerTLSCACert string
+	DockerTLSCert   string
+	DockerTLSKey    string
+
+	// name of the docker CLI context to connect to, the DOCKER_* environment variables are used if empty
+	DockerContext string
+
+	// OTLP HTTP endpoint URL for exporting trace spans, tracing is disabled if empty
+	OtelEndpoint string
+}
+
+// LoadConfig reads the configuration from the environment and validates it.
+// All validation errors are returned joined.
+func LoadConfig() (DexConfig, error) {
+	cfg := DexConfig{
+		Port:                     8080,
+		MetricPrefix:             defaultMetricPrefix,
+		StaleThreshold:           120 * time.Second,
+		FdMetricsInterval:        60 * time.Second,
+		FsMetricsInterval:        300 * time.Second,
+		MaxCardinality:           10000,
+		SamplingRate:             1,
+		ScrapeConcurrency:        10,
+		ScrapeTimeout:            30 * time.Second,
+		MemoryLimitWarnThreshold: 0.95,
+		ComposeLabels:            true,
+	}
+
+	var errs []error
+
+	if strPort, isSet := os.LookupEnv("DEX_PORT"); isSet {
+		intPort, err := strconv.Atoi(strPort)
+		if err != nil || intPort < 1 || intPort > 65535 {
+			errs = append(errs, fmt.Errorf("DEX_PORT: invalid port '%s', must be between 1 and 65535", strPort))
+		} else {
+			cfg.Port = intPort
+		}
+	}
+
+	if strPrefix, isSet := os.LookupEnv("DEX_METRIC_PREFIX"); isSet {
+		if !metricPrefixPattern.MatchString(strPrefix) {
+			errs = append(errs, fmt.Errorf("DEX_METRIC_PREFIX: invalid prefix '%s', must match %s", strPrefix, metricPrefixPattern))
+		} else {
+			cfg.MetricPrefix = strPrefix
+		}
+	}
+
+	cfg.TLSCert = os.Getenv("DEX_TLS_CERT")
+	cfg.TLSKey = os.Getenv("DEX_TLS_KEY")
+	cfg.TLSClientCA = os.Getenv("DEX_TLS_CLIENT_CA")
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("DEX_TLS_CERT and DEX_TLS_KEY must be set together"))
+	}
+
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		errs = append(errs, errors.New("DEX_TLS_CLIENT_CA requires DEX_TLS_CERT and DEX_TLS_KEY"))
+	}
+
+	lookupBool("DEX_BLOCK_IO_PER_DEVICE", &cfg.BlockIoPerDevice, &errs)
+
+	if strPrefixes, isSet := os.LookupEnv("DEX_LABEL_PREFIX_FILTER"); isSet {
+		cfg.LabelPrefixFilter = splitList(strPrefixes)
+	}
+
+	lookupRegexp("DEX_INCLUDE_CONTAINERS", &cfg.IncludeContainers, &errs)
+
+	lookupRegexp("DEX_EXCLUDE_CONTAINERS", &cfg.ExcludeContainers, &errs)
+
+	if strLabels, isSet := os.LookupEnv("DEX_EXTRA_LABELS"); isSet {
+		cfg.ExtraLabels = splitList(strLabels)
+	}
+
+	lookupBool("DEX_COMPOSE_LABELS", &cfg.ComposeLabels, &errs)
+
+	lookupSeconds("DEX_STALE_THRESHOLD_SECONDS", &cfg.StaleThreshold, &errs)
+
+	lookupBool("DEX_CPU_HISTOGRAM", &cfg.CPUHistogram, &errs)
+
+	lookupBool("DEX_MEMORY_PRESSURE_EVENTS", &cfg.MemoryPressureEvents, &errs)
+
+	lookupBool("DEX_EXEC_COUNT_METRICS", &cfg.ExecCountMetrics, &errs)
+
+	if strThreshold, isSet := os.LookupEnv("DEX_MEMORY_LIMIT_WARN_THRESHOLD"); isSet {
+		floatThreshold, err := strconv.ParseFloat(strThreshold, 64)
+		if err != nil || floatThreshold <= 0 || floatThreshold > 1 {
+			errs = append(errs, fmt.Errorf("DEX_MEMORY_LIMIT_WARN_THRESHOLD: invalid value '%s', must be greater than 0.0 and at most 1.0", strThreshold))
+		} else {
+			cfg.MemoryLimitWarnThreshold = floatThreshold
+		}
+	}
+
+	lookupBool("DEX_THREAD_METRICS", &cfg.ThreadMetrics, &errs)
+
+	lookupBool("DEX_FD_METRICS", &cfg.FdMetrics, &errs)
+
+	lookupSeconds("DEX_FD_METRICS_INTERVAL_SECONDS", &cfg.FdMetricsInterval, &errs)
+
+	lookupBool("DEX_TCP_METRICS", &cfg.TcpMetrics, &errs)
+
+	lookupBool("DEX_FS_METRICS", &cfg.FsMetrics, &errs)
+
+	lookupSeconds("DEX_FS_METRICS_INTERVAL_SECONDS", &cfg.FsMetricsInterval, &errs)
+
+	if strMax, isSet := os.LookupEnv("DEX_MAX_CONTAINERS"); isSet {
+		intMax, err := strconv.Atoi(strMax)
+		if err != nil || intMax < 0 {
+			errs = append(errs, fmt.Errorf("DEX_MAX_CONTAINERS: invalid value '%s', must be 0 or a positive number", strMax))
+		} else {
+			cfg.MaxContainers = intMax
+		}
+	}
+
+	if strRate, isSet := os.LookupEnv("DEX_SAMPLING_RATE"); isSet {
+		floatRate, err := strconv.ParseFloat(strRate, 64)
+		if err != nil || floatRate < 0 || floatRate > 1 {
+			errs = append(errs, fmt.Errorf("DEX_SAMPLING_RATE: invalid value '%s', must be between 0.0 and 1.0", strRate))
+		} else {
+			cfg.SamplingRate = floatRate
+		}
+	}
+
+	if strConcurrency, isSet := os.LookupEnv("DEX_SCRAPE_CONCURRENCY"); isSet {
+		intConcurrency, err := strconv.Atoi(strConcurrency)
+		if err != nil || intConcurrency < 1 {
+			errs = append(errs, fmt.Errorf("DEX_SCRAPE_CONCURRENCY: invalid value '%s', must be a positive number", strConcurrency))
+		} else {
+			cfg.ScrapeConcurrency = intConcurrency
+		}
+	}
+
+	if strTimeout, isSet := os.LookupEnv("DEX_SCRAPE_TIMEOUT"); isSet {
+		timeout, err := time.ParseDuration(strTimeout)
+		if err != nil || timeout <= 0 {
+			errs = append(errs, fmt.Errorf("DEX_SCRAPE_TIMEOUT: invalid value '%s', must be a positive duration like 30s", strTimeout))
+		} else {
+			cfg.ScrapeTimeout = timeout
+		}
+	}
+
+	if strTTL, isSet := os.LookupEnv("DEX_CACHE_TTL"); isSet {
+		ttl, err := time.ParseDuration(strTTL)
+		if err != nil || ttl < 0 {
+			errs = append(errs, fmt.Errorf("DEX_CACHE_TTL: invalid value '%s', must be a duration like 10s, 0s disables the cache", strTTL))
+		} else {
+			cfg.CacheTTL = ttl
+		}
+	}
+
+	if strMax, isSet := os.LookupEnv("DEX_MAX_CARDINALITY"); isSet {
+		intMax, err := strconv.Atoi(strMax)
+		if err != nil || intMax < 0 {
+			errs = append(errs, fmt.Errorf("DEX_MAX_CARDINALITY: invalid value '%s', must be 0 or a positive number", strMax))
+		} else {
+			cfg.MaxCardinality = intMax
+		}
+	}
+
+	cfg.DockerHost = os.Getenv("DEX_DOCKER_HOST")
+
+	// a bare socket path is the address of a unix socket
+	if strings.HasPrefix(cfg.DockerHost, "/") {
+		cfg.DockerHost = "unix://" + cfg.DockerHost
+	}
+	cfg.DockerTLSCACert = os.Getenv("DEX_DOCKER_TLS_CACERT")
+	cfg.DockerTLSCert = os.Getenv("DEX_DOCKER_TLS_CERT")
+	cfg.DockerTLSKey = os.Getenv("DEX_DOCKER_TLS_KEY")
+
+	if (cfg.DockerTLSCert == "") != (cfg.DockerTLSKey == "") {
+		errs = append(errs, errors.New("DEX_DOCKER_TLS_CERT and DEX_DOCKER_TLS_KEY must be set together"))
+	}
+
+	if cfg.DockerTLS() && !strings.HasPrefix(cfg.DockerHost, "tcp://") {
+		errs = append(errs, errors.New("DEX_DOCKER_TLS_CACERT, DEX_DOCKER_TLS_CERT and DEX_DOCKER_TLS_KEY require a tcp:// address in DEX_DOCKER_HOST"))
+	}
+
+	cfg.DockerContext = os.Getenv("DEX_DOCKER_CONTEXT")
+
+	if cfg.DockerHost != "" && cfg.DockerContext != "" {
+		errs = append(errs, errors.New("DEX_DOCKER_HOST and DEX_DOCKER_CONTEXT can't be set together"))
+	}
+
+	cfg.OtelEndpoint = os.Getenv("DEX_OTEL_ENDPOINT")
+
+	return cfg, errors.Join(errs...)
+}
+
+// DockerTLS returns true if the docker daemon is connected with TLS
+func (cfg DexConfig) DockerTLS() bool {
+	return cfg.DockerTLSCACert != "" || cfg.DockerTLSCert != ""
+}
+
+// splitList splits a comma separated list, empty entries are dropped
+func splitList(s string) []string {
+	var list []string
+
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// lookupBool sets target to the boolean value of the environment variable if it is set
+func lookupBool(name string, target *bool, errs *[]error) {
+	if strValue, isSet := os.LookupEnv(name); isSet {
+		boolValue, err := strconv.ParseBool(strValue)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: invalid value '%s', must be true or false", name, strValue))
+		} else {
+			*target = boolValue
+		}
+	}
+}
+
+// lookupRegexp sets target to the compiled regular expression of the environment variable if it is set
+func lookupRegexp(name string, target **regexp.Regexp, errs *[]error) {
+	if strValue, isSet := os.LookupEnv(name); isSet {
+		re, err := regexp.Compile(strValue)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: invalid regular expression '%s': %w", name, strValue, err))
+		} else {
+			*target = re
+		}
+	}
+}
+
+// lookupSeconds sets target to the duration of the environment variable in seconds if it is set
+func lookupSeconds(name string, target *time.Duration, errs *[]error) {
+	if strValue, isSet := os.LookupEnv(name); isSet {
+		intValue, err := strconv.Atoi(strValue)
+		if err != nil || intValue < 1 {
+			*errs = append(*errs, fmt.Errorf("%s: invalid value '%s', must be a positive number of seconds", name, strValue))
+		} else {
+			*target = time.Duration(intValue) * time.Second
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+func TestLoadConfig_DockerHostSocketPath(t *testing.T) {
+	for host, want := range map[string]string{
+		"/run/user/1000/docker.sock":        "unix:///run/user/1000/docker.sock",
+		"unix:///run/user/1000/docker.sock": "unix:///run/user/1000/docker.sock",
+		"tcp://127.0.0.1:2375":              "tcp://127.0.0.1:2375",
+	} {
+		t.Setenv("DEX_DOCKER_HOST", host)
+
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("can't load config: %v", err)
+		}
+
+		if cfg.DockerHost != want {
+			t.Errorf("DockerHost of DEX_DOCKER_HOST '%s' = %s, want %s", host, cfg.DockerHost, want)
+		}
+	}
+}
+
+func TestNewDockerCollector_DockerHostSocketPath(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("can't listen on socket: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(d.serveHTTP))
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	t.Setenv("DEX_DOCKER_HOST", socket)
+
+	// DEX_DOCKER_HOST overrides DOCKER_HOST of the fake daemon
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:1")
+
+	families := gather(t, newTestCollector(t))
+
+	if findMetric(families, "dex_container_running", map[string]string{"container_name": "web"}) == nil {
+		t.Error("metrics of the container behind the socket are missing")
+	}
+}
+
+func TestLoadConfig_DockerTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+		wantTLS bool
+	}{
+		{
+			name: "plain tcp",
+			env:  map[string]string{"DEX_DOCKER_HOST": "tcp://127.0.0.1:2376"},
+		},
+		{
+			name:    "ca only",
+			env:     map[string]string{"DEX_DOCKER_HOST": "tcp://127.0.0.1:2376", "DEX_DOCKER_TLS_CACERT": "ca.pem"},
+			wantTLS: true,
+		},
+		{
+			name:    "client certificate",
+			env:     map[string]string{"DEX_DOCKER_HOST": "tcp://127.0.0.1:2376", "DEX_DOCKER_TLS_CACERT": "ca.pem", "DEX_DOCKER_TLS_CERT": "cert.pem", "DEX_DOCKER_TLS_KEY": "key.pem"},
+			wantTLS: true,
+		},
+		{
+			name:    "certificate without key",
+			env:     map[string]string{"DEX_DOCKER_HOST": "tcp://127.0.0.1:2376", "DEX_DOCKER_TLS_CERT": "cert.pem"},
+			wantErr: "DEX_DOCKER_TLS_CERT and DEX_DOCKER_TLS_KEY must be set together",
+		},
+		{
+			name:    "key without certificate",
+			env:     map[string]string{"DEX_DOCKER_HOST": "tcp://127.0.0.1:2376", "DEX_DOCKER_TLS_KEY": "key.pem"},
+			wantErr: "DEX_DOCKER_TLS_CERT and DEX_DOCKER_TLS_KEY must be set together",
+		},
+		{
+			name:    "unix socket",
+			env:     map[string]string{"DEX_DOCKER_HOST": "/var/run/docker.sock", "DEX_DOCKER_TLS_CACERT": "ca.pem"},
+			wantErr: "require a tcp:// address in DEX_DOCKER_HOST",
+		},
+		{
+			name:    "no host",
+			env:     map[string]string{"DEX_DOCKER_TLS_CACERT": "ca.pem"},
+			wantErr: "require a tcp:// address in DEX_DOCKER_HOST",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := LoadConfig()
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("can't load config: %v", err)
+			}
+
+			if cfg.DockerTLS() != tt.wantTLS {
+				t.Errorf("DockerTLS() = %v, want %v", cfg.DockerTLS(), tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestNewDockerCollector_DockerTLS(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	dir := t.TempDir()
+	certFile, keyFile, clientCAs := writeTestCertificate(t, dir, "dex")
+
+	// the daemon requires a client certificate like dockerd with --tlsverify
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(d.serveHTTP))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	t.Run("client certificate", func(t *testing.T) {
+		t.Setenv("DEX_DOCKER_HOST", host)
+		t.Setenv("DEX_DOCKER_TLS_CACERT", caFile)
+		t.Setenv("DEX_DOCKER_TLS_CERT", certFile)
+		t.Setenv("DEX_DOCKER_TLS_KEY", keyFile)
+
+		families := gather(t, newTestCollector(t))
+
+		if findMetric(families, "dex_container_running", map[string]string{"container_name": "web"}) == nil {
+			t.Error("metrics of the container behind TLS are missing")
+		}
+	})
+
+	t.Run("handshake failure", func(t *testing.T) {
+		for name, opt := range map[string]client.Opt{
+			// the daemon rejects the connection without client certificate
+			"no client certificate": client.WithTLSClientConfig(caFile, "", ""),
+			// the daemon certificate isn't signed by the CA
+			"unknown daemon CA": client.WithTLSClientConfig(certFile, certFile, keyFile),
+		} {
+			cli, err := client.NewClientWithOpts(client.WithHost(host), opt)
+			if err != nil {
+				t.Fatalf("%s: can't create docker client: %v", name, err)
+			}
+
+			if err := verifyTLSConnection(cli); err == nil {
+				t.Errorf("%s: TLS connection verified, want handshake error", name)
+			}
+		}
+	})
+}
+
+// writeTestCertificate writes a self-signed client certificate and its key as PEM files to dir and
+// returns the files and a pool for verifying the certificate
+func writeTestCertificate(t *testing.T, dir, name string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("can't create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("can't parse certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("can't marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+"-cert.pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("can't write %s: %v", file, err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// lastScrape is a gatherer which keeps the metrics of its last scrape
+type lastScrape struct {
+	prometheus.Gatherer
+
+	mu sync.Mutex
+
+	families []*dto.MetricFamily
+}
+
+func (g *lastScrape) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	g.mu.Lock()
+	g.families = families
+	g.mu.Unlock()
+
+	return families, err
+}
+
+// last returns the metrics of the last scrape, nil before the first scrape
+func (g *lastScrape) last() []*dto.MetricFamily {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.families
+}
+
+// containerStatsHandler responds with the container metrics of the last scrape as JSON array, one
+// object per container. Histograms are left out. It doesn't scrape itself, because a scrape changes
+// the state kept between scrapes, e.g. the counters of created containers
+func containerStatsHandler(scrapes *lastScrape) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(containerStatsOf(scrapes.last())); err != nil {
+			log.Error("can't encode container stats: ", err)
+		}
+	}
+}
+
+// containerStatsOf groups the samples of the metric families with a container_name label by container
+func containerStatsOf(families []*dto.MetricFamily) []containerStats {
+	byName := make(map[string]*containerStats)
+
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			value, ok := sampleValue(m)
+			if !ok {
+				continue
+			}
+
+			var cName string
+
+			labels := make(map[string]string)
+
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "container_name" {
+					cName = label.GetValue()
+				} else {
+					labels[label.GetName()] = label.GetValue()
+				}
+			}
+
+			if cName == "" {
+				continue
+			}
+
+			stats, ok := byName[cName]
+			if !ok {
+				stats = &containerStats{Name: cName}
+				byName[cName] = stats
+			}
+
+			stats.Metrics = append(stats.Metrics, containerMetric{Name: family.GetName(), Labels: labels, Value: value})
+		}
+	}
+
+	result := make([]containerStats, 0, len(byName))
+	for _, stats := range byName {
+		result = append(result, *stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// sampleValue returns the value of a counter, gauge or untyped metric. Infinite and NaN values, e.g.
+// of unlimited limits, can't be encoded as JSON number and are left out
+func sampleValue(m *dto.Metric) (float64, bool) {
+	var value float64
+
+	switch {
+	case m.Counter != nil:
+		value = m.GetCounter().GetValue()
+	case m.Gauge != nil:
+		value = m.GetGauge().GetValue()
+	case m.Untyped != nil:
+		value = m.GetUntyped().GetValue()
+	default:
+		return 0, false
+	}
+
+	return value, !math.IsInf(value, 0) && !math.IsNaN(value)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestContainerStatsHandler_ServesLastScrape(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newTestCollector(t))
+
+	scrapes := &lastScrape{Gatherer: reg}
+	handler := containerStatsHandler(scrapes)
+
+	serve := func() []containerStats {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/metrics/containers", nil))
+
+		var stats []containerStats
+		if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+			t.Fatalf("can't decode container stats: %v", err)
+		}
+
+		return stats
+	}
+
+	if stats := serve(); len(stats) != 0 {
+		t.Errorf("container stats before the first scrape = %v, want none", stats)
+	}
+
+	if _, err := scrapes.Gather(); err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+
+	lists := d.requestCount("/containers/json")
+
+	stats := serve()
+	if len(stats) != 1 || stats[0].Name != "web" || len(stats[0].Metrics) == 0 {
+		t.Errorf("container stats = %v, want the metrics of web", stats)
+	}
+
+	// the JSON endpoint doesn't scrape the docker daemon
+	if got := d.requestCount("/containers/json"); got != lists {
+		t.Errorf("container list requests = %d, want %d", got, lists)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// deviceNumber resolves a block device path like /dev/sda to its "major:minor" number
+func deviceNumber(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || fi.Mode()&os.ModeDevice == 0 {
+		return "", fmt.Errorf("%s is not a device", path)
+	}
+
+	// Rdev is uint32 on some architectures
+	dev := uint64(st.Rdev)
+
+	return fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev)), nil
+}
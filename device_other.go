@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// deviceNumber resolves a block device path like /dev/sda to its "major:minor" number
+func deviceNumber(_ string) (string, error) {
+	return "", errors.New("device numbers are only supported on linux")
+}
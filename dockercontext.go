@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// dockerContextMeta is the part of the docker CLI context metadata used by dex
+type dockerContextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// dockerConfigDir returns the configuration directory of the docker CLI
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".docker"), nil
+}
+
+// dockerContextOpts returns the client options connecting to the docker endpoint of the named docker
+// CLI context. The context store keeps the metadata and TLS files in directories named by the SHA-256
+// digest of the context name
+func dockerContextOpts(configDir string, name string) ([]client.Opt, error) {
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+
+	content, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return nil, fmt.Errorf("can't read docker context '%s': %w", name, err)
+	}
+
+	var meta dockerContextMeta
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("can't parse docker context '%s': %w", name, err)
+	}
+
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return nil, fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+
+	opts := []client.Opt{client.WithHost(endpoint.Host)}
+
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		if endpoint.SkipTLSVerify {
+			log.Warn("SkipTLSVerify of docker context is not supported, the server certificate is verified")
+		}
+
+		opts = append(opts, client.WithTLSClientConfig(
+			optionalFile(filepath.Join(tlsDir, "ca.pem")),
+			optionalFile(filepath.Join(tlsDir, "cert.pem")),
+			optionalFile(filepath.Join(tlsDir, "key.pem")),
+		))
+	}
+
+	return opts, nil
+}
+
+// optionalFile returns the path if the file exists, otherwise an empty string
+func optionalFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+
+	return path
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+func TestDockerContextOpts(t *testing.T) {
+	d := newFakeDaemon(t)
+	plainHost := os.Getenv("DOCKER_HOST")
+
+	configDir := t.TempDir()
+	tlsDir := filepath.Join(configDir, "contexts", "tls", dockerContextID("secure"), "docker")
+
+	if err := os.MkdirAll(tlsDir, 0o700); err != nil {
+		t.Fatalf("can't create TLS directory: %v", err)
+	}
+
+	certFile, keyFile, clientCAs := writeTestCertificate(t, tlsDir, "client")
+
+	for from, to := range map[string]string{certFile: "cert.pem", keyFile: "key.pem"} {
+		if err := os.Rename(from, filepath.Join(tlsDir, to)); err != nil {
+			t.Fatalf("can't rename %s: %v", from, err)
+		}
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(d.serveHTTP))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	writePEM(t, filepath.Join(tlsDir, "ca.pem"), "CERTIFICATE", srv.Certificate().Raw)
+
+	tlsHost := "tcp://" + srv.Listener.Addr().String()
+
+	writeDockerContext(t, configDir, "plain", `{"Name":"plain","Endpoints":{"docker":{"Host":"`+plainHost+`"}}}`)
+	writeDockerContext(t, configDir, "secure", `{"Name":"secure","Endpoints":{"docker":{"Host":"`+tlsHost+`"}}}`)
+	writeDockerContext(t, configDir, "no-tls-dir", `{"Name":"no-tls-dir","Endpoints":{"docker":{"Host":"`+tlsHost+`"}}}`)
+	writeDockerContext(t, configDir, "kubernetes", `{"Name":"kubernetes","Endpoints":{"kubernetes":{"Host":"https://k8s.example.com"}}}`)
+
+	for _, tc := range []struct {
+		name     string
+		wantHost string
+		wantErr  bool
+	}{
+		{"plain", plainHost, false},
+		// CA and client certificate of the TLS directory
+		{"secure", tlsHost, false},
+		// the TLS daemon rejects the plaintext connection without TLS directory
+		{"no-tls-dir", tlsHost, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := dockerContextOpts(configDir, tc.name)
+			if err != nil {
+				t.Fatalf("can't get options of docker context: %v", err)
+			}
+
+			cli, err := client.NewClientWithOpts(opts...)
+			if err != nil {
+				t.Fatalf("can't create docker client: %v", err)
+			}
+
+			if cli.DaemonHost() != tc.wantHost {
+				t.Errorf("DaemonHost() = %s, want %s", cli.DaemonHost(), tc.wantHost)
+			}
+
+			if err := verifyTLSConnection(cli); (err != nil) != tc.wantErr {
+				t.Errorf("ping error = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	for name, wantErr := range map[string]string{
+		"missing":    "can't read docker context 'missing'",
+		"kubernetes": "docker context 'kubernetes' has no docker endpoint",
+	} {
+		if _, err := dockerContextOpts(configDir, name); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("error of docker context '%s' = %v, want %q", name, err, wantErr)
+		}
+	}
+}
+
+// dockerContextID returns the directory name of the docker context in the context store
+func dockerContextID(name string) string {
+	digest := sha256.Sum256([]byte(name))
+
+	return hex.EncodeToString(digest[:])
+}
+
+// writeDockerContext writes the metadata of the docker context to the context store in configDir
+func writeDockerContext(t *testing.T, configDir, name, meta string) {
+	t.Helper()
+
+	dir := filepath.Join(configDir, "contexts", "meta", dockerContextID(name))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatalf("can't create context directory: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), []byte(meta), 0o600); err != nil {
+		t.Fatalf("can't write context metadata: %v", err)
+	}
+}
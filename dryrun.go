@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// runDryRun checks the docker connection, collects the metrics once and writes them in the
+// Prometheus text format to out. Summary and warnings are logged
+func runDryRun(c *DockerCollector, reg *prometheus.Registry, out io.Writer) error {
+	if _, err := c.cli.Ping(context.Background()); err != nil {
+		return fmt.Errorf("can't connect to docker: %w", err)
+	}
+
+	containers, err := c.cli.ContainerList(context.Background(), container.ListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("can't list containers: %w", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		return fmt.Errorf("can't collect metrics: %w", err)
+	}
+
+	encoder := expfmt.NewEncoder(out, expfmt.NewFormat(expfmt.TypeTextPlain))
+
+	var metrics int
+
+	for _, family := range families {
+		metrics += len(family.GetMetric())
+
+		switch family.GetName() {
+		case c.metricPrefix + "_container_network_stats_missing":
+			for _, m := range family.GetMetric() {
+				log.Warn("no network stats: ", m.GetLabel())
+			}
+		case c.metricPrefix + "_scrape_errors_total":
+			for _, m := range family.GetMetric() {
+				log.Warnf("%v scrape errors: %v", m.GetCounter().GetValue(), m.GetLabel())
+			}
+		}
+
+		if err := encoder.Encode(family); err != nil {
+			return fmt.Errorf("can't encode metrics: %w", err)
+		}
+	}
+
+	log.Infof("collected %d metrics in %d metric families of %d containers", metrics, len(families), len(containers))
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRunDryRun_WritesTextFormat(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "broken", "nginx:1.25", "running")
+	d.update(func(d *fakeDaemon) { delete(d.stats, "bbbb") })
+
+	c := newTestCollector(t)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	hook := test.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+
+	var out bytes.Buffer
+	if err := runDryRun(c, reg, &out); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(&out)
+	if err != nil {
+		t.Fatalf("output is no valid Prometheus text format: %v", err)
+	}
+
+	for _, name := range []string{"dex_container_state", "dex_scrape_errors_total"} {
+		if families[name] == nil {
+			t.Errorf("%s missing in the output", name)
+		}
+	}
+
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel && strings.Contains(entry.Message, "scrape errors") && strings.Contains(entry.Message, "broken") {
+			warned = true
+		}
+	}
+
+	if !warned {
+		t.Error("no warning about the scrape errors of broken")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+)
+
+// delay before subscribing again after the event stream failed
+const eventsRetryDelay = 5 * time.Second
+
+// watchEvents subscribes to the docker events of the given type and actions and calls handle
+// for each event. The subscription is renewed when the stream fails until ctx is done
+func (c *DockerCollector) watchEvents(ctx context.Context, eventType events.Type, handle func(events.Message), actions ...events.Action) {
+	args := filters.NewArgs(filters.Arg("type", string(eventType)))
+	for _, action := range actions {
+		args.Add("event", string(action))
+	}
+
+	for {
+		messages, errs := c.cli.Events(ctx, events.ListOptions{Filters: args})
+
+	receive:
+		for {
+			select {
+			case msg := <-messages:
+				handle(msg)
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+
+				log.Error("docker event stream failed: ", err)
+
+				break receive
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsRetryDelay):
+		}
+	}
+}
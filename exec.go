@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// maximal duration of a command executed inside a container to not block the scrape
+const execTimeout = 2 * time.Second
+
+// execInContainer runs the command inside the container and returns its standard output.
+// A non-zero exit code is returned as error. The command is canceled with ctx or after execTimeout
+func (c *DockerCollector) execInContainer(ctx context.Context, containerID string, cmd ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, execTimeout)
+	defer cancel()
+
+	exec, err := c.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't create exec: %w", err)
+	}
+
+	resp, err := c.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", fmt.Errorf("can't attach to exec: %w", err)
+	}
+	defer resp.Close()
+
+	// the hijacked connection doesn't observe the context
+	deadline, _ := ctx.Deadline()
+	if err := resp.Conn.SetReadDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader); err != nil {
+		return "", fmt.Errorf("can't read exec output: %w", err)
+	}
+
+	inspect, err := c.cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return "", fmt.Errorf("can't inspect exec: %w", err)
+	}
+
+	if inspect.ExitCode != 0 {
+		return "", fmt.Errorf("'%s' exited with code %d: %s", strings.Join(cmd, " "), inspect.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
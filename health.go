@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// timeout of the docker ping of the health check
+const healthTimeout = 3 * time.Second
+
+// healthHandler responds with 200 if the docker daemon of the collector is reachable, 503 otherwise
+func (c *DockerCollector) healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	if _, err := c.cli.Ping(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok"))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	d := newFakeDaemon(t)
+	c := newTestCollector(t)
+
+	for _, tc := range []struct {
+		name      string
+		pingFails bool
+		want      int
+	}{
+		{"daemon reachable", false, http.StatusOK},
+		{"daemon unavailable", true, http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d.update(func(d *fakeDaemon) { d.pingFails = tc.pingFails })
+
+			rec := httptest.NewRecorder()
+			c.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
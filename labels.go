@@ -0,0 +1,166 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// characters of docker label keys which are not allowed in Prometheus label names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// docker compose labels added with fixed Prometheus label names
+var composeLabels = []extraLabel{
+	{name: "compose_project", key: "com.docker.compose.project"},
+	{name: "compose_service", key: "com.docker.compose.service"},
+}
+
+// docker swarm labels added with fixed Prometheus label names, empty if the host is not in swarm mode
+var swarmLabels = []extraLabel{
+	{name: "swarm_service", key: "com.docker.swarm.service.name"},
+	{name: "swarm_task", key: "com.docker.swarm.task.name"},
+}
+
+// extraLabel is a docker label of the container added as Prometheus label to all its metrics
+type extraLabel struct {
+	// Prometheus label name
+	name string
+
+	// docker label key
+	key string
+}
+
+// newExtraLabels maps docker label keys to Prometheus label names. The names get the prefix "label_",
+// so they can't collide with the labels of the metrics
+func newExtraLabels(keys []string) []extraLabel {
+	labels := make([]extraLabel, 0, len(keys))
+
+	for _, key := range keys {
+		labels = append(labels, extraLabel{name: "label_" + invalidLabelChars.ReplaceAllString(key, "_"), key: key})
+	}
+
+	return labels
+}
+
+// dockerLabels returns the compose and extra labels added to the metrics of the containers
+func (c *DockerCollector) dockerLabels() []extraLabel {
+	if c.composeLabels {
+		return append(slices.Clone(composeLabels), c.extraLabels...)
+	}
+
+	return c.extraLabels
+}
+
+// containerLabelNames returns the names of the labels added by containerLabelPairs in the same order
+func (c *DockerCollector) containerLabelNames() []string {
+	names := []string{"image_name", "image_tag"}
+
+	for _, label := range append(slices.Clone(swarmLabels), c.dockerLabels()...) {
+		names = append(names, label.name)
+	}
+
+	return names
+}
+
+// containerLabelPairs returns the image, swarm, extra and compose label pairs of a container, missing
+// docker labels are empty
+func (c *DockerCollector) containerLabelPairs(cont types.Container, swarmMode bool) []*dto.LabelPair {
+	labels := c.dockerLabels()
+
+	imageName, imageTag := parseImageRef(cont.Image)
+
+	// references by digest only have no tag
+	if strings.HasPrefix(imageTag, "sha256:") {
+		imageTag = ""
+	}
+
+	pairs := make([]*dto.LabelPair, 0, len(labels)+len(swarmLabels)+2)
+	pairs = append(pairs, labelPair("image_name", imageName), labelPair("image_tag", imageTag))
+
+	for _, label := range swarmLabels {
+		var value string
+		if swarmMode {
+			value = cont.Labels[label.key]
+		}
+
+		pairs = append(pairs, labelPair(label.name, value))
+	}
+
+	for _, label := range labels {
+		pairs = append(pairs, labelPair(label.name, cont.Labels[label.key]))
+	}
+
+	return pairs
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// withLabelPairs returns a channel which adds the label pairs to all metrics sent to it, replaces their
+// descriptor templates with the descriptors of the collector and forwards them to ch. The returned
+// function must be called after the last metric was sent
+func (c *DockerCollector) withLabelPairs(ch chan<- prometheus.Metric, pairs []*dto.LabelPair) (chan<- prometheus.Metric, func()) {
+	labeled := make(chan prometheus.Metric)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for metric := range labeled {
+			ch <- labeledMetric{Metric: metric, desc: c.desc(metric.Desc()), pairs: pairs}
+		}
+	}()
+
+	return labeled, func() {
+		close(labeled)
+		<-done
+	}
+}
+
+// labeledMetric adds label pairs to a metric and replaces its descriptor by the one declaring the added
+// labels. Labels the metric already has are kept
+type labeledMetric struct {
+	prometheus.Metric
+
+	desc *prometheus.Desc
+
+	pairs []*dto.LabelPair
+}
+
+func (m labeledMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+
+	for _, pair := range m.pairs {
+		if !hasLabel(out.Label, pair.GetName()) {
+			out.Label = append(out.Label, pair)
+		}
+	}
+
+	sort.Slice(out.Label, func(i, j int) bool {
+		return out.Label[i].GetName() < out.Label[j].GetName()
+	})
+
+	return nil
+}
+
+func hasLabel(labels []*dto.LabelPair, name string) bool {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
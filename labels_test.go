@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDockerCollector_DescribesContainerLabels(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+	d.addContainer("bbbb", "job", "busybox@sha256:abcd", "exited")
+	d.update(func(d *fakeDaemon) {
+		d.containers[0].Labels = map[string]string{"com.docker.compose.project": "shop", "team": "a"}
+		d.info.CgroupVersion = "2"
+
+		inspect := d.inspects["aaaa"]
+		inspect.HostConfig.Memory = 1 << 30
+		inspect.HostConfig.MemorySwap = -1
+		inspect.HostConfig.BlkioDeviceReadBps = []*blkiodev.ThrottleDevice{{Path: "/dev/sda", Rate: 1000}}
+		inspect.State.Health = &types.Health{Status: "unhealthy", Log: []*types.HealthcheckResult{{ExitCode: 1, Output: "down"}}}
+		d.inspects["aaaa"] = inspect
+
+		stats := d.stats["aaaa"]
+		stats.BlkioStats.IoServiceBytesRecursive = []container.BlkioStatEntry{{Major: 8, Op: "Read", Value: 100}}
+		stats.Networks = map[string]container.NetworkStats{"eth0": {RxBytes: 10}}
+		d.stats["aaaa"] = stats
+	})
+
+	t.Setenv("DEX_EXTRA_LABELS", "team,tenant.acme.com/app")
+	t.Setenv("DEX_BLOCK_IO_PER_DEVICE", "true")
+	t.Setenv("DEX_CPU_HISTOGRAM", "true")
+	t.Setenv("DEX_EXEC_COUNT_METRICS", "true")
+	t.Setenv("DEX_MEMORY_PRESSURE_EVENTS", "true")
+
+	c := newTestCollector(t)
+
+	// the pedantic registry of the linter checks the metrics against the descriptors
+	if _, err := testutil.CollectAndLint(NewCardinalityLimiter(c, 100, c.metricPrefix)); err != nil {
+		t.Fatalf("inconsistent metrics: %v", err)
+	}
+
+	families := gather(t, c)
+
+	for _, tc := range []struct {
+		cName  string
+		labels map[string]string
+	}{
+		{"web", map[string]string{
+			"image_name": "nginx", "image_tag": "1.25", "swarm_service": "", "swarm_task": "",
+			"compose_project": "shop", "compose_service": "", "label_team": "a", "label_tenant_acme_com_app": "",
+		}},
+		{"job", map[string]string{"image_name": "busybox", "image_tag": "", "label_team": ""}},
+	} {
+		tc.labels["container_name"] = tc.cName
+
+		if findMetric(families, "dex_container_running", tc.labels) == nil {
+			t.Errorf("dex_container_running with labels %v is missing", tc.labels)
+		}
+	}
+
+	// OOM events are counted for exited containers, too
+	if got := metricValue(t, families, "dex_container_memory_pressure_events_total", map[string]string{"container_name": "job", "level": "oom"}); got != 0 {
+		t.Errorf("dex_container_memory_pressure_events_total of job = %v, want 0", got)
+	}
+
+	// labels of the metric itself are kept
+	if findMetric(families, "dex_container_image_tag_info", map[string]string{"container_name": "web", "image_name": "nginx"}) == nil {
+		t.Error("dex_container_image_tag_info of web is missing")
+	}
+}
+
+func TestNewDescs_ContainerLabels(t *testing.T) {
+	descs := newDescs("dex", []string{"image_name", "label_team"})
+
+	reg := prometheus.NewPedanticRegistry()
+
+	metric := prometheus.MustNewConstMetric(descs[containerImageTagInfoDesc], prometheus.GaugeValue, 1, "web", "nginx", "1.25", "a")
+	host := prometheus.MustNewConstMetric(descs[hostContainersTotalDesc], prometheus.GaugeValue, 1)
+
+	reg.MustRegister(collectorFunc(func(ch chan<- prometheus.Metric) {
+		ch <- metric
+		ch <- host
+	}))
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("can't gather metrics: %v", err)
+	}
+}
+
+func TestDockerCollector_SwarmLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		nodeState   swarm.LocalNodeState
+		wantService string
+		wantTask    string
+	}{
+		{name: "active", nodeState: swarm.LocalNodeStateActive, wantService: "shop_web", wantTask: "shop_web.1.abcd"},
+		// stale labels of a node which left the swarm are ignored
+		{name: "inactive", nodeState: swarm.LocalNodeStateInactive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFakeDaemon(t)
+			d.addContainer("aaaa", "web", "nginx:1.25", "running")
+			d.update(func(d *fakeDaemon) {
+				d.info.Swarm.LocalNodeState = tt.nodeState
+				d.containers[0].Labels = map[string]string{
+					"com.docker.swarm.service.name": "shop_web",
+					"com.docker.swarm.task.name":    "shop_web.1.abcd",
+				}
+			})
+
+			families := gather(t, newTestCollector(t))
+
+			labels := map[string]string{"container_name": "web", "swarm_service": tt.wantService, "swarm_task": tt.wantTask}
+			if findMetric(families, "dex_container_running", labels) == nil {
+				t.Errorf("dex_container_running with labels %v is missing", labels)
+			}
+		})
+	}
+}
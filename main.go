@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,22 +18,56 @@ import (
 )
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "collect the metrics once, print them to stdout and exit")
+	flag.Parse()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	shutdownTracing := func(context.Context) error { return nil }
+
+	if cfg.OtelEndpoint != "" {
+		if shutdownTracing, err = setupTracing(cfg.OtelEndpoint); err != nil {
+			log.Fatalf("can't set up tracing: %v", err)
+		}
+	}
+
+	// stops watching the docker events
+	ctx, cancel := context.WithCancel(context.Background())
+
 	reg := prometheus.NewRegistry()
-	reg.MustRegister(newDockerCollector())
+	dockerCollector := newDockerCollector(ctx, cfg)
 
-	router := http.NewServeMux()
-	router.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{
-		Registry: reg,
-	}))
+	var collector prometheus.Collector = dockerCollector
+	if cfg.MaxCardinality > 0 {
+		collector = NewCardinalityLimiter(collector, cfg.MaxCardinality, cfg.MetricPrefix)
+	}
 
-	serverPort := 8080
+	reg.MustRegister(collector)
 
-	if strPort, isSet := os.LookupEnv("DEX_PORT"); isSet {
-		if intPort, err := strconv.Atoi(strPort); err == nil {
-			serverPort = intPort
+	if *dryRun {
+		if err := runDryRun(dockerCollector, reg, os.Stdout); err != nil {
+			log.Error("dry run failed: ", err)
+			os.Exit(1)
 		}
+
+		os.Exit(0)
 	}
 
+	// the JSON endpoint serves the metrics of the last scrape of /metrics
+	scrapes := &lastScrape{Gatherer: reg}
+
+	router := http.NewServeMux()
+	router.Handle("/metrics", promhttp.HandlerFor(scrapes, promhttp.HandlerOpts{
+		Registry: reg,
+	}))
+	router.HandleFunc("/metrics/containers", containerStatsHandler(scrapes))
+	router.HandleFunc("/healthz", dockerCollector.healthHandler)
+
+	serverPort := cfg.Port
+
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%v", serverPort),
 		Handler:      router,
@@ -40,6 +76,12 @@ func main() {
 		IdleTimeout:  15 * time.Second,
 	}
 
+	if cfg.TLSClientCA != "" {
+		if server.TLSConfig, err = clientCATLSConfig(cfg.TLSClientCA); err != nil {
+			log.Fatalf("can't load client CA: %v", err)
+		}
+	}
+
 	done := make(chan bool)
 
 	quit := make(chan os.Signal, 1)
@@ -49,20 +91,51 @@ func main() {
 		<-quit
 		log.Info("Server is shutting down...")
 
+		cancel()
+
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
 			log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
 		}
+
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("can't flush trace spans: ", err)
+		}
 		close(done)
 	}()
 
 	log.Info("Server is ready to handle requests at :", serverPort)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+	listen := server.ListenAndServe
+	if cfg.TLSCert != "" {
+		listen = func() error { return server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey) }
+	}
+
+	if err := listen(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Could not listen on %d: %v\n", serverPort, err)
 	}
 
 	<-done
 	log.Info("Server stopped")
 }
+
+// clientCATLSConfig requires clients to present a certificate signed by a CA of the PEM file
+func clientCATLSConfig(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in '%s'", caFile)
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientCATLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	// the client certificate is self-signed, so it is its own CA
+	certFile, keyFile, _ := writeTestCertificate(t, dir, "scraper")
+	otherCertFile, otherKeyFile, _ := writeTestCertificate(t, dir, "other")
+
+	tlsConfig, err := clientCATLSConfig(certFile)
+	if err != nil {
+		t.Fatalf("can't load client CA: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("metrics"))
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// clientWithCertificate returns a client trusting the server with the client certificate
+	clientWithCertificate := func(certFile, keyFile string) *http.Client {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("can't load client certificate: %v", err)
+		}
+
+		client := srv.Client()
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		client.Transport = transport
+
+		return client
+	}
+
+	t.Run("client certificate", func(t *testing.T) {
+		resp, err := clientWithCertificate(certFile, keyFile).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("can't get metrics: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		// the TLS server answers plain HTTP with 400 Bad Request or closes the connection
+		resp, err := http.Get("http://" + srv.Listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status of plaintext request = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+
+	for name, client := range map[string]*http.Client{
+		"no client certificate":     srv.Client(),
+		"certificate of another CA": clientWithCertificate(otherCertFile, otherKeyFile),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("status = %d, want handshake error", resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("invalid CA file", func(t *testing.T) {
+		noCerts := filepath.Join(dir, "empty.pem")
+		if err := os.WriteFile(noCerts, []byte("no certificates"), 0o600); err != nil {
+			t.Fatalf("can't write CA file: %v", err)
+		}
+
+		if _, err := clientCATLSConfig(noCerts); err == nil || !strings.Contains(err.Error(), "no certificates found") {
+			t.Errorf("error = %v, want no certificates found", err)
+		}
+
+		if _, err := clientCATLSConfig(filepath.Join(dir, "missing.pem")); err == nil {
+			t.Error("missing CA file loaded, want error")
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer delegates to the global tracer provider, it is a no-op until setupTracing was called
+var tracer = otel.Tracer("dex")
+
+// setupTracing exports the trace spans with OTLP over HTTP to the endpoint URL.
+// The returned function flushes the pending spans and stops the export
+func setupTracing(endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("dex"))),
+	)
+
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// startSpan starts a child span of ctx for a container
+func startSpan(ctx context.Context, name string, cName string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(append(attrs, attribute.String("container.name", cName))...))
+}
+
+// startAPISpan starts a child span of ctx for a docker API call for a container
+func startAPISpan(ctx context.Context, call string, cName string) (context.Context, trace.Span) {
+	return startSpan(ctx, call, cName, attribute.String("docker.api.call", call))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCollect_TracingSpans(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.addContainer("aaaa", "web", "nginx:1.25", "running")
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+
+	// spans of later tests are dropped by the stopped provider
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	gather(t, newTestCollector(t))
+
+	spans := make(map[string]map[attribute.Key]string)
+	for _, span := range recorder.Ended() {
+		attrs := make(map[attribute.Key]string)
+		for _, attr := range span.Attributes() {
+			attrs[attr.Key] = attr.Value.Emit()
+		}
+
+		spans[span.Name()] = attrs
+	}
+
+	for _, tc := range []struct {
+		span    string
+		apiCall string
+	}{
+		{"processContainer", ""},
+		{"ContainerInspect", "ContainerInspect"},
+		{"ContainerStats", "ContainerStats"},
+	} {
+		attrs, ok := spans[tc.span]
+		if !ok {
+			t.Errorf("span %s is missing", tc.span)
+			continue
+		}
+
+		if got := attrs["container.name"]; got != "web" {
+			t.Errorf("container.name of span %s = %q, want web", tc.span, got)
+		}
+
+		if got := attrs["docker.api.call"]; got != tc.apiCall {
+			t.Errorf("docker.api.call of span %s = %q, want %q", tc.span, got, tc.apiCall)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricCollector is a stage of the metric collection for running containers.
+// Custom collectors can be registered with DockerCollector.AddCollector
+type MetricCollector interface {
+	Collect(ch chan<- prometheus.Metric, data *ContainerData)
+}
+
+// MetricCollectorFunc adapts a function to a MetricCollector
+type MetricCollectorFunc func(ch chan<- prometheus.Metric, data *ContainerData)
+
+// Collect calls f(ch, data)
+func (f MetricCollectorFunc) Collect(ch chan<- prometheus.Metric, data *ContainerData) {
+	f(ch, data)
+}
+
+// ContainerData is passed to each MetricCollector for a running container
+type ContainerData struct {
+	// context of the scrape, the docker API calls of the collector must be derived from it
+	Ctx context.Context
+
+	// container ID
+	ID string
+
+	// value of the container_name label
+	Name string
+
+	Inspect *types.ContainerJSON
+
+	Stats *container.StatsResponse
+
+	// data shared by all containers of the scrape
+	Scrape *scrapeInfo
+}
+
+// containerStats holds the metrics of a container served by the /metrics/containers endpoint
+type containerStats struct {
+	// value of the container_name label
+	Name string `json:"container_name"`
+
+	Metrics []containerMetric `json:"metrics"`
+}
+
+// containerMetric is a sample of a container metric
+type containerMetric struct {
+	Name string `json:"name"`
+
+	// labels besides container_name
+	Labels map[string]string `json:"labels"`
+
+	Value float64 `json:"value"`
+}